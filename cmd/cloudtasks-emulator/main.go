@@ -0,0 +1,124 @@
+// Command cloudtasks-emulator serves the google.cloud.tasks.v2.CloudTasks gRPC API from memory
+// and dispatches HTTP target tasks when they are due.
+//
+// Clients connect to it without TLS or authentication:
+//
+//	cli, err := cloudtasks.NewClient(ctx,
+//		option.WithEndpoint("localhost:8123"),
+//		option.WithoutAuthentication(),
+//		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+//	)
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/grpc"
+
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+type config struct {
+	addr         string
+	queues       []string
+	pollInterval time.Duration
+	tombstoneTTL time.Duration
+	strictQueues bool
+}
+
+func main() {
+	cfg := &config{}
+	var queues string
+	flag.StringVar(&cfg.addr, "addr", envOr("CLOUDTASKS_EMULATOR_ADDR", ":8123"), "address to listen on ($CLOUDTASKS_EMULATOR_ADDR)")
+	flag.StringVar(&queues, "queues", os.Getenv("CLOUDTASKS_EMULATOR_QUEUES"), "comma separated queue names (projects/P/locations/L/queues/Q) to create at startup ($CLOUDTASKS_EMULATOR_QUEUES)")
+	flag.DurationVar(&cfg.pollInterval, "poll-interval", time.Second, "maximum interval between checks for due tasks")
+	flag.DurationVar(&cfg.tombstoneTTL, "tombstone-ttl", time.Hour, "how long names of deleted tasks can't be reused")
+	flag.BoolVar(&cfg.strictQueues, "strict-queues", false, "reject tasks for queues which were not created")
+	flag.Parse()
+	for _, q := range strings.Split(queues, ",") {
+		if q = strings.TrimSpace(q); q != "" {
+			cfg.queues = append(cfg.queues, q)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	lis, err := net.Listen("tcp", cfg.addr)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	log.Printf("cloudtasks-emulator listening on %s", lis.Addr())
+
+	if err := run(ctx, lis, cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, lis net.Listener, cfg *config) error {
+	e, err := schedulertest.NewLocalExecutor(ctx,
+		schedulertest.WithPollInterval(cfg.pollInterval),
+		schedulertest.WithServerOptions(serverOptions(cfg)...),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start executor: %w", err)
+	}
+	defer e.Close()
+
+	for _, name := range cfg.queues {
+		idx := strings.LastIndex(name, "/queues/")
+		if idx < 0 {
+			return fmt.Errorf("invalid queue name %q", name)
+		}
+		if _, err := e.Server.CreateQueue(ctx, &taskspb.CreateQueueRequest{
+			Parent: name[:idx],
+			Queue:  &taskspb.Queue{Name: name},
+		}); err != nil {
+			return fmt.Errorf("failed to create queue %s: %w", name, err)
+		}
+	}
+
+	gs := grpc.NewServer(grpc.UnaryInterceptor(e.Server.UnaryInterceptor))
+	taskspb.RegisterCloudTasksServer(gs, e.Server)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- gs.Serve(lis)
+	}()
+	go e.Start(ctx)
+
+	select {
+	case <-ctx.Done():
+		gs.GracefulStop()
+		return nil
+	case err := <-errCh:
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+}
+
+func serverOptions(cfg *config) []schedulertest.ServerOption {
+	opts := []schedulertest.ServerOption{schedulertest.WithTombstoneTTL(cfg.tombstoneTTL)}
+	if cfg.strictQueues {
+		opts = append(opts, schedulertest.WithStrictQueues())
+	}
+
+	return opts
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return def
+}
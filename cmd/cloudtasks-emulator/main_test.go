@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/go-oss/scheduler"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan *http.Request, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+	}))
+	defer ts.Close()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- run(ctx, lis, &config{
+			queues:       []string{queuePath},
+			pollInterval: 10 * time.Millisecond,
+			tombstoneTTL: time.Hour,
+			strictQueues: true,
+		})
+	}()
+
+	cli, err := cloudtasks.NewClient(ctx,
+		option.WithEndpoint(lis.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	q, err := cli.GetQueue(ctx, &taskspb.GetQueueRequest{Name: queuePath})
+	require.NoError(t, err)
+	assert.Equal(t, taskspb.Queue_RUNNING, q.State)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/hello", nil)
+	require.NoError(t, err)
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+	require.NoError(t, s.Sync(ctx, []*scheduler.Task{
+		{
+			QueuePath:   queuePath,
+			Prefix:      "test_",
+			ID:          "hello",
+			ScheduledAt: time.Now(),
+			Request:     req,
+		},
+	}))
+
+	select {
+	case r := <-received:
+		assert.Equal(t, "/hello", r.URL.Path)
+		assert.Equal(t, "scheduler", r.Header.Get("X-CloudTasks-QueueName"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("task was not dispatched")
+	}
+
+	cancel()
+	assert.NoError(t, <-errCh)
+}
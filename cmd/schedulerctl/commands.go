@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-oss/scheduler"
+)
+
+var commands = map[string]func(a *app, ctx context.Context, args []string) error{
+	"list":    (*app).list,
+	"get":     (*app).get,
+	"plan":    (*app).plan,
+	"sync":    (*app).sync,
+	"delete":  (*app).delete,
+	"purge":   (*app).purge,
+	"run-now": (*app).runNow,
+}
+
+func (a *app) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	return fs
+}
+
+// parseFlags parses flags interspersed with the positional arguments, such as `get <id> -at <time>`.
+// Arguments after "--" are all positional.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	var positional, rest []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, rest = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return err
+			}
+			return fmt.Errorf("%v: %w", err, errUsage)
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	// leave the positional arguments in fs.Args
+	return fs.Parse(append(append([]string{"--"}, positional...), rest...))
+}
+
+type timeFlag struct {
+	t **time.Time
+}
+
+func (f *timeFlag) String() string {
+	if f.t == nil || *f.t == nil {
+		return ""
+	}
+	return (*f.t).Format(time.RFC3339Nano)
+}
+
+func (f *timeFlag) Set(s string) error {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return fmt.Errorf("must be RFC3339: %w", err)
+	}
+	*f.t = &t
+	return nil
+}
+
+func (a *app) list(ctx context.Context, args []string) error {
+	fs := a.flagSet("list")
+	output := fs.String("o", "table", "output format (table|json)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	s, cli, err := a.scheduler(ctx, a.prefix)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	tasks, err := collect(ctx, s, func(*scheduler.Task) bool { return true })
+	if err != nil {
+		return err
+	}
+
+	return a.printTasks(*output, tasks)
+}
+
+func (a *app) get(ctx context.Context, args []string) error {
+	fs := a.flagSet("get")
+	output := fs.String("o", "table", "output format (table|json)")
+	var at *time.Time
+	fs.Var(&timeFlag{&at}, "at", "scheduled time of the task (RFC3339)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("get takes exactly one id: %w", errUsage)
+	}
+
+	s, cli, err := a.scheduler(ctx, a.prefix)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	tasks, err := findByID(ctx, s, fs.Arg(0), at)
+	if err != nil {
+		return err
+	}
+
+	return a.printTasks(*output, tasks)
+}
+
+func (a *app) plan(ctx context.Context, args []string) error {
+	fs := a.flagSet("plan")
+	file := fs.String("f", "", "manifest file")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	_, err := a.syncManifest(ctx, *file, false)
+	return err
+}
+
+func (a *app) sync(ctx context.Context, args []string) error {
+	fs := a.flagSet("sync")
+	file := fs.String("f", "", "manifest file")
+	dryRun := fs.Bool("dry-run", false, "only show the changes")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	_, err := a.syncManifest(ctx, *file, !*dryRun)
+	return err
+}
+
+func (a *app) syncManifest(ctx context.Context, file string, apply bool) (*scheduler.Plan, error) {
+	if file == "" {
+		return nil, fmt.Errorf("-f is required: %w", errUsage)
+	}
+
+	s, cli, err := a.scheduler(ctx, a.prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	tasks, err := scheduler.LoadManifest(file, a.queuePath(), a.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := s.Plan(ctx, tasks)
+	if err != nil {
+		return nil, err
+	}
+	a.printPlan(plan)
+
+	if !apply || plan.Empty() {
+		return plan, nil
+	}
+	if err := s.Apply(ctx, plan); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(a.stdout, "applied: %d deleted, %d created\n", len(plan.Delete), len(plan.Create))
+
+	return plan, nil
+}
+
+func (a *app) delete(ctx context.Context, args []string) error {
+	fs := a.flagSet("delete")
+	var at *time.Time
+	fs.Var(&timeFlag{&at}, "at", "only delete the task scheduled at this time (RFC3339)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("delete takes at least one id: %w", errUsage)
+	}
+
+	s, cli, err := a.scheduler(ctx, a.prefix)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	for _, id := range fs.Args() {
+		tasks, err := findByID(ctx, s, id, at)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range tasks {
+			if err := s.Delete(ctx, t.TaskName()); err != nil {
+				return err
+			}
+			fmt.Fprintf(a.stdout, "deleted %s\n", t.TaskName())
+		}
+	}
+
+	return nil
+}
+
+func (a *app) purge(ctx context.Context, args []string) error {
+	fs := a.flagSet("purge")
+	prefix := fs.String("prefix", a.prefix, "task id prefix to purge")
+	yes := fs.Bool("yes", false, "delete without confirmation; otherwise only list the tasks")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *prefix == "" {
+		return fmt.Errorf("refusing to purge with an empty prefix: %w", errUsage)
+	}
+
+	s, cli, err := a.scheduler(ctx, *prefix)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	if !*yes {
+		tasks, err := collect(ctx, s, func(*scheduler.Task) bool { return true })
+		if err != nil {
+			return err
+		}
+		for _, t := range tasks {
+			fmt.Fprintf(a.stdout, "would delete %s\n", t.TaskName())
+		}
+		fmt.Fprintf(a.stdout, "%d tasks would be deleted; rerun with -yes to delete them\n", len(tasks))
+		return nil
+	}
+
+	_, err = s.Purge(ctx, nil, scheduler.WithPurgeProgress(func(p scheduler.PurgeProgress) {
+		if p.Err != nil {
+			fmt.Fprintf(a.stderr, "[%d/%d] failed to delete %s: %v\n", p.Deleted+p.Failed, p.Total, p.Task.TaskName(), p.Err)
+			return
+		}
+		fmt.Fprintf(a.stdout, "[%d/%d] deleted %s\n", p.Deleted+p.Failed, p.Total, p.Task.TaskName())
+	}))
+
+	return err
+}
+
+func (a *app) runNow(ctx context.Context, args []string) error {
+	fs := a.flagSet("run-now")
+	var at *time.Time
+	fs.Var(&timeFlag{&at}, "at", "scheduled time of the task (RFC3339); defaults to the next occurrence")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("run-now takes exactly one id: %w", errUsage)
+	}
+
+	s, cli, err := a.scheduler(ctx, a.prefix)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	var name string
+	if at != nil {
+		name, err = s.RunNow(ctx, fs.Arg(0), *at)
+	} else {
+		name, err = s.RunNext(ctx, fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "dispatched %s\n", name)
+
+	return nil
+}
+
+// findByID returns the tasks of id, only the ones scheduled at at if at is not nil.
+func findByID(ctx context.Context, s *scheduler.Scheduler, id string, at *time.Time) ([]*scheduler.Task, error) {
+	tasks, err := s.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*scheduler.Task
+	for _, t := range tasks {
+		if at == nil || t.ScheduledAt.Equal(*at) {
+			found = append(found, t)
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%s: %w", id, scheduler.ErrTaskNotFound)
+	}
+
+	return found, nil
+}
+
+// collect returns the tasks matching f ordered by scheduled time, id and version.
+func collect(ctx context.Context, s *scheduler.Scheduler, f func(*scheduler.Task) bool) ([]*scheduler.Task, error) {
+	var tasks []*scheduler.Task
+	iter := s.List()
+	for {
+		t, err := iter.Next(ctx)
+		if errors.Is(err, scheduler.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if f(t) {
+			tasks = append(tasks, t)
+		}
+	}
+
+	sort.SliceStable(tasks, func(i, j int) bool {
+		if !tasks[i].ScheduledAt.Equal(tasks[j].ScheduledAt) {
+			return tasks[i].ScheduledAt.Before(tasks[j].ScheduledAt)
+		}
+		if tasks[i].ID != tasks[j].ID {
+			return tasks[i].ID < tasks[j].ID
+		}
+		return tasks[i].Version < tasks[j].Version
+	})
+
+	return tasks, nil
+}
+
+type taskView struct {
+	Name          string    `json:"name"`
+	ID            string    `json:"id"`
+	ScheduledAt   time.Time `json:"scheduledAt"`
+	Version       int       `json:"version"`
+	Method        string    `json:"method"`
+	URL           string    `json:"url"`
+	Authorization string    `json:"authorization,omitempty"`
+}
+
+func newTaskView(t *scheduler.Task) *taskView {
+	v := &taskView{
+		Name:        t.TaskName(),
+		ID:          t.ID,
+		ScheduledAt: t.ScheduledAt,
+		Version:     t.Version,
+		Method:      t.Request.Method,
+		URL:         t.Request.URL.String(),
+	}
+	switch token := t.Authorization.(type) {
+	case *scheduler.OIDCToken:
+		v.Authorization = "oidc:" + token.ServiceAccountEmail
+	case *scheduler.OAuthToken:
+		v.Authorization = "oauth:" + token.ServiceAccountEmail
+	}
+
+	return v
+}
+
+func (a *app) printTasks(format string, tasks []*scheduler.Task) error {
+	views := make([]*taskView, 0, len(tasks))
+	for _, t := range tasks {
+		views = append(views, newTaskView(t))
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(a.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(views)
+	case "table":
+		return printTable(a.stdout, views)
+	default:
+		return fmt.Errorf("unknown output format %q: %w", format, errUsage)
+	}
+}
+
+func printTable(w io.Writer, views []*taskView) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSCHEDULED_AT\tVERSION\tMETHOD\tURL\tAUTHORIZATION")
+	for _, v := range views {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", v.ID, v.ScheduledAt.Format(time.RFC3339), v.Version, v.Method, v.URL, v.Authorization)
+	}
+	return tw.Flush()
+}
+
+func (a *app) printPlan(plan *scheduler.Plan) {
+	for _, t := range plan.Delete {
+		fmt.Fprintf(a.stdout, "- %s\n", t.TaskID())
+	}
+	for _, t := range plan.Create {
+		fmt.Fprintf(a.stdout, "+ %s\n", t.TaskID())
+	}
+	fmt.Fprintf(a.stdout, "plan: %d to create, %d to delete, %d unchanged\n", len(plan.Create), len(plan.Delete), len(plan.Unchanged))
+}
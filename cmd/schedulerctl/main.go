@@ -0,0 +1,145 @@
+// Command schedulerctl inspects and manages tasks scheduled by github.com/go-oss/scheduler.
+//
+// Usage:
+//
+//	schedulerctl [global flags] <command> [flags] [args]
+//
+// Commands:
+//
+//	list                      list tasks under the prefix
+//	get <id>                  show the tasks of an id
+//	plan -f <manifest>        show the changes sync would make
+//	sync -f <manifest>        make the queue match the manifest
+//	delete <id>...            delete the tasks of ids
+//	purge --prefix <prefix>   delete every task under a prefix
+//	run-now <id>              dispatch a task immediately
+//
+// Command flags can be given before or after the arguments. Arguments after "--" are never flags.
+//
+// Global flags default to the SCHEDULER_PROJECT, SCHEDULER_LOCATION, SCHEDULER_QUEUE,
+// SCHEDULER_PREFIX and SCHEDULER_ENDPOINT environment variables.
+// Setting the endpoint connects to cloudtasks-emulator instead of Cloud Tasks.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/go-oss/scheduler"
+)
+
+type client interface {
+	scheduler.CloudTasksClient
+	Close() error
+}
+
+var _ client = (*cloudtasks.Client)(nil)
+
+var errUsage = errors.New("usage error")
+
+type app struct {
+	newClient func(ctx context.Context, endpoint string) (client, error)
+	getenv    func(string) string
+	stdout    io.Writer
+	stderr    io.Writer
+
+	project  string
+	location string
+	queue    string
+	prefix   string
+	endpoint string
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	a := &app{
+		newClient: newClient,
+		getenv:    os.Getenv,
+		stdout:    os.Stdout,
+		stderr:    os.Stderr,
+	}
+	os.Exit(a.run(ctx, os.Args[1:]))
+}
+
+// newClient connects to Cloud Tasks, or to an emulator without TLS and authentication if endpoint is given.
+func newClient(ctx context.Context, endpoint string) (client, error) {
+	if endpoint == "" {
+		return cloudtasks.NewClient(ctx)
+	}
+
+	return cloudtasks.NewClient(ctx,
+		option.WithEndpoint(endpoint),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+}
+
+func (a *app) run(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("schedulerctl", flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.StringVar(&a.project, "project", a.getenv("SCHEDULER_PROJECT"), "GCP project id ($SCHEDULER_PROJECT)")
+	fs.StringVar(&a.location, "location", a.getenv("SCHEDULER_LOCATION"), "Cloud Tasks location ($SCHEDULER_LOCATION)")
+	fs.StringVar(&a.queue, "queue", a.getenv("SCHEDULER_QUEUE"), "Cloud Tasks queue ($SCHEDULER_QUEUE)")
+	fs.StringVar(&a.prefix, "prefix", a.getenv("SCHEDULER_PREFIX"), "task id prefix ($SCHEDULER_PREFIX)")
+	fs.StringVar(&a.endpoint, "endpoint", a.getenv("SCHEDULER_ENDPOINT"), "emulator address such as localhost:8123 ($SCHEDULER_ENDPOINT)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: schedulerctl [global flags] <list|get|plan|sync|delete|purge|run-now> [flags] [args]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(a.stderr, "unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+
+	if err := cmd(a, ctx, fs.Args()[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(a.stderr, "schedulerctl %s: %v\n", fs.Arg(0), err)
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		return 1
+	}
+
+	return 0
+}
+
+func (a *app) queuePath() string {
+	return scheduler.QueuePath(a.project, a.location, a.queue)
+}
+
+func (a *app) scheduler(ctx context.Context, prefix string) (*scheduler.Scheduler, client, error) {
+	if a.project == "" || a.location == "" || a.queue == "" {
+		return nil, nil, fmt.Errorf("project, location and queue are required: %w", errUsage)
+	}
+
+	cli, err := a.newClient(ctx, a.endpoint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	return scheduler.New(cli, a.project, a.location, a.queue, prefix), cli, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+const queuePath = "projects/tokyo-rain-123/locations/asia-northeast1/queues/scheduler"
+
+type fakeClient struct {
+	*schedulertest.FakeClient
+}
+
+// Close keeps the fake alive across commands; it is closed by the test cleanup.
+func (fakeClient) Close() error {
+	return nil
+}
+
+func newTestApp(t *testing.T, tasks ...*taskspb.Task) (*app, *schedulertest.Server, *bytes.Buffer) {
+	t.Helper()
+
+	cli, err := schedulertest.NewFakeClient(context.Background())
+	require.NoError(t, err)
+	t.Cleanup(func() { cli.Close() })
+	for _, task := range tasks {
+		cli.Server.AddTask(task)
+	}
+
+	stdout := &bytes.Buffer{}
+	a := &app{
+		newClient: func(context.Context, string) (client, error) {
+			return fakeClient{cli}, nil
+		},
+		getenv: func(k string) string {
+			return map[string]string{
+				"SCHEDULER_PROJECT":  "tokyo-rain-123",
+				"SCHEDULER_LOCATION": "asia-northeast1",
+				"SCHEDULER_QUEUE":    "scheduler",
+				"SCHEDULER_PREFIX":   "test_",
+			}[k]
+		},
+		stdout: stdout,
+		stderr: &bytes.Buffer{},
+	}
+
+	return a, cli.Server, stdout
+}
+
+func names(srv *schedulertest.Server) []string {
+	var names []string
+	for _, t := range srv.Tasks(queuePath) {
+		names = append(names, strings.TrimPrefix(t.Name, queuePath+"/tasks/"))
+	}
+	return names
+}
+
+func pbTask(id string, scheduledAt time.Time, version int) *taskspb.Task {
+	return &taskspb.Task{
+		Name:         queuePath + "/tasks/" + id + "_" + strconv.FormatInt(scheduledAt.UnixNano(), 16) + "v" + strconv.Itoa(version),
+		ScheduleTime: timestamppb.New(scheduledAt),
+		MessageType: &taskspb.Task_HttpRequest{
+			HttpRequest: &taskspb.HttpRequest{
+				Url:        "https://example.com/",
+				HttpMethod: taskspb.HttpMethod_GET,
+			},
+		},
+	}
+}
+
+func TestApp_list(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	a, _, stdout := newTestApp(t,
+		pbTask("test_b", time.Unix(20, 0).UTC(), 1),
+		pbTask("test_a", time.Unix(10, 0).UTC(), 2),
+		pbTask("other_c", time.Unix(10, 0).UTC(), 1),
+	)
+
+	require.Equal(t, 0, a.run(ctx, []string{"list", "-o", "json"}))
+
+	var got []*taskView
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &got))
+	require.Len(t, got, 2)
+	assert.Equal(t, "a", got[0].ID)
+	assert.Equal(t, 2, got[0].Version)
+	assert.Equal(t, "b", got[1].ID)
+}
+
+func TestApp_get(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	a, _, stdout := newTestApp(t, pbTask("test_a", time.Unix(10, 0).UTC(), 1))
+
+	require.Equal(t, 0, a.run(ctx, []string{"get", "a"}))
+	assert.Contains(t, stdout.String(), "https://example.com/")
+
+	assert.Equal(t, 1, a.run(ctx, []string{"get", "unknown"}))
+
+	stdout.Reset()
+	require.Equal(t, 0, a.run(ctx, []string{"get", "a", "-o", "json", "-at", time.Unix(10, 0).UTC().Format(time.RFC3339)}), "flags after the id")
+	assert.Contains(t, stdout.String(), `"id": "a"`)
+
+	assert.Equal(t, 2, a.run(ctx, []string{"get", "a", "-unknown"}))
+	assert.Equal(t, 1, a.run(ctx, []string{"get", "--", "-o"}), "arguments after -- are ids")
+}
+
+func TestApp_sync(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	scheduledAt := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	a, srv, stdout := newTestApp(t, pbTask("test_stale", scheduledAt, 1))
+
+	file := filepath.Join(t.TempDir(), "manifest.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+tasks:
+  - id: fresh
+    time: `+scheduledAt.Format(time.RFC3339)+`
+    method: GET
+    url: https://example.com/
+`), 0o600))
+
+	require.Equal(t, 0, a.run(ctx, []string{"plan", "-f", file}))
+	assert.Contains(t, stdout.String(), "plan: 1 to create, 1 to delete, 0 unchanged")
+	assert.Equal(t, []string{"test_stale_" + strconv.FormatInt(scheduledAt.UnixNano(), 16) + "v1"}, names(srv))
+
+	require.Equal(t, 0, a.run(ctx, []string{"sync", "-f", file}))
+	assert.Equal(t, []string{"test_fresh_" + strconv.FormatInt(scheduledAt.UnixNano(), 16) + "v1"}, names(srv))
+}
+
+func TestApp_delete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	a, srv, _ := newTestApp(t,
+		pbTask("test_a", time.Unix(10, 0).UTC(), 1),
+		pbTask("test_a", time.Unix(20, 0).UTC(), 1),
+		pbTask("test_b", time.Unix(10, 0).UTC(), 1),
+	)
+
+	require.Equal(t, 0, a.run(ctx, []string{"delete", "-at", time.Unix(20, 0).UTC().Format(time.RFC3339), "a"}))
+	assert.Equal(t, []string{"test_a_2540be400v1", "test_b_2540be400v1"}, names(srv))
+
+	require.Equal(t, 0, a.run(ctx, []string{"delete", "a", "b"}))
+	assert.Empty(t, names(srv))
+}
+
+func TestApp_purge(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	a, srv, stdout := newTestApp(t,
+		pbTask("test_a", time.Unix(10, 0).UTC(), 1),
+		pbTask("old_b", time.Unix(10, 0).UTC(), 1),
+	)
+
+	require.Equal(t, 0, a.run(ctx, []string{"purge", "--prefix", "old_"}))
+	assert.Contains(t, stdout.String(), "1 tasks would be deleted")
+	assert.Len(t, names(srv), 2)
+
+	require.Equal(t, 0, a.run(ctx, []string{"purge", "--prefix", "old_", "-yes"}))
+	assert.Equal(t, []string{"test_a_2540be400v1"}, names(srv))
+}
+
+func TestApp_runNow(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	a, srv, _ := newTestApp(t,
+		pbTask("test_a", time.Unix(20, 0).UTC(), 1),
+		pbTask("test_a", time.Unix(10, 0).UTC(), 1),
+		pbTask("test_a", time.Unix(10, 0).UTC(), 2),
+	)
+
+	require.Equal(t, 0, a.run(ctx, []string{"run-now", "a"}))
+	assert.Equal(t, []string{"test_a_2540be400v1", "test_a_4a817c800v1"}, names(srv))
+}
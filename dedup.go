@@ -0,0 +1,487 @@
+package scheduler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrDedupLeaseLost = errors.New("dedup lease lost")
+
+type DedupState int
+
+const (
+	// DedupAcquired means the caller holds the lease and should process the delivery.
+	DedupAcquired DedupState = iota
+	// DedupInProgress means another delivery holds an unexpired lease.
+	DedupInProgress
+	// DedupCompleted means a delivery has already been processed.
+	DedupCompleted
+)
+
+// DedupStore records the processing state of deliveries keyed by task name.
+type DedupStore interface {
+	// Acquire takes a lease on key for lease unless key is leased or completed.
+	Acquire(ctx context.Context, key string, lease time.Duration) (DedupState, error)
+	// Renew extends the lease on key to lease from now. It returns ErrDedupLeaseLost if key is not leased.
+	Renew(ctx context.Context, key string, lease time.Duration) error
+	// Complete marks key as completed, and keeps the record for retention.
+	Complete(ctx context.Context, key string, retention time.Duration) error
+	// Release drops the lease on key so that a retry can acquire it.
+	Release(ctx context.Context, key string) error
+}
+
+type dedupRecord struct {
+	Completed bool      `json:"completed"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type dedupRecords map[string]dedupRecord
+
+func (r dedupRecords) acquire(key string, now time.Time, lease time.Duration) DedupState {
+	if rec, ok := r[key]; ok && now.Before(rec.ExpiresAt) {
+		if rec.Completed {
+			return DedupCompleted
+		}
+		return DedupInProgress
+	}
+	r[key] = dedupRecord{ExpiresAt: now.Add(lease)}
+
+	return DedupAcquired
+}
+
+func (r dedupRecords) renew(key string, now time.Time, lease time.Duration) error {
+	if rec, ok := r[key]; !ok || rec.Completed || !now.Before(rec.ExpiresAt) {
+		return fmt.Errorf("%s: %w", key, ErrDedupLeaseLost)
+	}
+	r[key] = dedupRecord{ExpiresAt: now.Add(lease)}
+
+	return nil
+}
+
+func (r dedupRecords) sweep(now time.Time) {
+	for key, rec := range r {
+		if !now.Before(rec.ExpiresAt) {
+			delete(r, key)
+		}
+	}
+}
+
+// MemoryDedupStore is a DedupStore kept in memory.
+type MemoryDedupStore struct {
+	mu        sync.Mutex
+	records   dedupRecords
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{
+		records: make(dedupRecords),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryDedupStore) Acquire(_ context.Context, key string, lease time.Duration) (DedupState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.records.sweep(now)
+		s.lastSweep = now
+	}
+
+	return s.records.acquire(key, now, lease), nil
+}
+
+func (s *MemoryDedupStore) Renew(_ context.Context, key string, lease time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.records.renew(key, s.now(), lease)
+}
+
+func (s *MemoryDedupStore) Complete(_ context.Context, key string, retention time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = dedupRecord{Completed: true, ExpiresAt: s.now().Add(retention)}
+	return nil
+}
+
+func (s *MemoryDedupStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.records[key]; ok && !rec.Completed {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+// dedupCompactionMinEntries is the log length below which FileDedupStore is not compacted.
+const dedupCompactionMinEntries = 1024
+
+// dedupLogEntry is a line of the log of FileDedupStore, which sets or deletes the record of key.
+type dedupLogEntry struct {
+	Key     string `json:"key"`
+	Deleted bool   `json:"deleted,omitempty"`
+	dedupRecord
+}
+
+// FileDedupStore is a DedupStore persisted to an append-only log of JSON lines.
+// It is meant for a single process; every change appends and syncs a line,
+// and the log is compacted into the records kept when it has grown to twice as many lines.
+// Use a database backed DedupStore for many deliveries within the retention.
+type FileDedupStore struct {
+	mu            sync.Mutex
+	path          string
+	file          *os.File
+	logLen        int
+	minCompaction int
+	records       dedupRecords
+	now           func() time.Time
+	lastSweep     time.Time
+}
+
+// OpenFileDedupStore loads the records from the log at path, which is created if it doesn't exist.
+// A line left incomplete by a crash is dropped. The store must be closed after use.
+func OpenFileDedupStore(path string) (*FileDedupStore, error) {
+	s := &FileDedupStore{
+		path:          path,
+		minCompaction: dedupCompactionMinEntries,
+		records:       make(dedupRecords),
+		now:           time.Now,
+	}
+
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read dedup store: %w", err)
+	default:
+		err := s.replay(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records.sweep(s.now())
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// replay applies the entries of the log in r to the records.
+func (s *FileDedupStore) replay(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// a line without the newline was not completely written
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read dedup store: %w", err)
+		}
+
+		var e dedupLogEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("failed to decode dedup store: %w", err)
+		}
+		if e.Deleted {
+			delete(s.records, e.Key)
+		} else {
+			s.records[e.Key] = e.dedupRecord
+		}
+	}
+}
+
+func (s *FileDedupStore) Acquire(_ context.Context, key string, lease time.Duration) (DedupState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.records.sweep(now)
+		s.lastSweep = now
+	}
+	state := s.records.acquire(key, now, lease)
+	if state != DedupAcquired {
+		return state, nil
+	}
+
+	return state, s.append(dedupLogEntry{Key: key, dedupRecord: s.records[key]})
+}
+
+func (s *FileDedupStore) Renew(_ context.Context, key string, lease time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.records.renew(key, s.now(), lease); err != nil {
+		return err
+	}
+
+	return s.append(dedupLogEntry{Key: key, dedupRecord: s.records[key]})
+}
+
+func (s *FileDedupStore) Complete(_ context.Context, key string, retention time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = dedupRecord{Completed: true, ExpiresAt: s.now().Add(retention)}
+	return s.append(dedupLogEntry{Key: key, dedupRecord: s.records[key]})
+}
+
+func (s *FileDedupStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.records[key]; !ok || rec.Completed {
+		return nil
+	}
+	delete(s.records, key)
+
+	return s.append(dedupLogEntry{Key: key, Deleted: true})
+}
+
+// Close closes the log.
+func (s *FileDedupStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to close dedup store: %w", err)
+	}
+
+	return nil
+}
+
+// append writes e to the log and syncs it, and compacts the log if it has grown enough.
+func (s *FileDedupStore) append(e dedupLogEntry) error {
+	if s.file == nil {
+		// the log was not reopened after the last compaction
+		return s.compact()
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode dedup store: %w", err)
+	}
+	if _, err := s.file.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write dedup store: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync dedup store: %w", err)
+	}
+	s.logLen++
+
+	if s.logLen < s.minCompaction || s.logLen <= 2*len(s.records) {
+		return nil
+	}
+	s.records.sweep(s.now())
+
+	return s.compact()
+}
+
+// compact writes the records to a temporary file, syncs it, renames it over the path
+// and reopens it to append.
+func (s *FileDedupStore) compact() error {
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create dedup store: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for key, rec := range s.records {
+		if err := enc.Encode(dedupLogEntry{Key: key, dedupRecord: rec}); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write dedup store: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write dedup store: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync dedup store: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write dedup store: %w", err)
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write dedup store: %w", err)
+	}
+
+	// the old file is replaced, so it must not be appended to anymore
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open dedup store: %w", err)
+	}
+	s.file = file
+	s.logLen = len(s.records)
+
+	return nil
+}
+
+type DeduplicatorOption func(*Deduplicator)
+
+// WithDedupLease sets how long a delivery in progress blocks duplicates. The default is 1 minute.
+// The lease is renewed every half of it while the next handler runs.
+func WithDedupLease(d time.Duration) DeduplicatorOption {
+	return func(dd *Deduplicator) {
+		dd.lease = d
+	}
+}
+
+// WithDedupRetention sets how long completed deliveries are remembered. The default is 24 hours.
+func WithDedupRetention(d time.Duration) DeduplicatorOption {
+	return func(dd *Deduplicator) {
+		dd.retention = d
+	}
+}
+
+// Deduplicator is a middleware which runs the next handler at most once per task name until it succeeds.
+// It must be wrapped by Receiver to get the task metadata.
+//
+// Duplicates of completed deliveries are answered with 200 OK,
+// and duplicates of deliveries in progress with 409 Conflict so that Cloud Tasks retries them later.
+type Deduplicator struct {
+	store     DedupStore
+	lease     time.Duration
+	retention time.Duration
+}
+
+func NewDeduplicator(store DedupStore, opts ...DeduplicatorOption) *Deduplicator {
+	d := &Deduplicator{
+		store:     store,
+		lease:     time.Minute,
+		retention: 24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+func (d *Deduplicator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := TaskInfoFromContext(r.Context())
+		if !ok {
+			http.Error(w, "task info is missing", http.StatusInternalServerError)
+			return
+		}
+
+		state, err := d.store.Acquire(r.Context(), info.TaskName, d.lease)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to acquire lease: %v", err), http.StatusInternalServerError)
+			return
+		}
+		switch state {
+		case DedupCompleted:
+			w.WriteHeader(http.StatusOK)
+			return
+		case DedupInProgress:
+			http.Error(w, "task is in progress", http.StatusConflict)
+			return
+		}
+
+		// the lease must be dropped even if next panics
+		completed := false
+		defer func() {
+			if !completed {
+				_ = d.store.Release(context.Background(), info.TaskName)
+			}
+		}()
+
+		stop := d.renew(r.Context(), info.TaskName)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		func() {
+			defer stop()
+			next.ServeHTTP(sw, r)
+		}()
+		if sw.status >= 200 && sw.status < 300 {
+			if err := d.store.Complete(r.Context(), info.TaskName, d.retention); err == nil {
+				completed = true
+			}
+		}
+	})
+}
+
+// renew renews the lease on key until the returned function is called, or the lease is lost.
+func (d *Deduplicator) renew(ctx context.Context, key string) func() {
+	if d.lease/2 <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(d.lease / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := d.store.Renew(ctx, key, d.lease); err != nil {
+					return
+				}
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,253 @@
+package scheduler_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oss/scheduler"
+)
+
+func TestDedupStore(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		newStore func(t *testing.T, now func() time.Time) scheduler.DedupStore
+	}{
+		{
+			name: "memory",
+			newStore: func(t *testing.T, now func() time.Time) scheduler.DedupStore {
+				s := scheduler.NewMemoryDedupStore()
+				s.SetNow(now)
+				return s
+			},
+		},
+		{
+			name: "file",
+			newStore: func(t *testing.T, now func() time.Time) scheduler.DedupStore {
+				s, err := scheduler.OpenFileDedupStore(filepath.Join(t.TempDir(), "dedup.json"))
+				require.NoError(t, err)
+				t.Cleanup(func() { s.Close() })
+				s.SetNow(now)
+				return s
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			now := time.Unix(0, 0)
+			s := tt.newStore(t, func() time.Time { return now })
+
+			state, err := s.Acquire(ctx, "task", time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, scheduler.DedupAcquired, state)
+
+			state, _ = s.Acquire(ctx, "task", time.Minute)
+			assert.Equal(t, scheduler.DedupInProgress, state)
+
+			now = now.Add(30 * time.Second)
+			require.NoError(t, s.Renew(ctx, "task", time.Minute))
+			now = now.Add(45 * time.Second)
+			state, _ = s.Acquire(ctx, "task", time.Minute)
+			assert.Equal(t, scheduler.DedupInProgress, state, "renewed lease is kept")
+
+			now = now.Add(time.Minute)
+			assert.ErrorIs(t, s.Renew(ctx, "task", time.Minute), scheduler.ErrDedupLeaseLost)
+			state, _ = s.Acquire(ctx, "task", time.Minute)
+			assert.Equal(t, scheduler.DedupAcquired, state, "expired lease is taken over")
+
+			require.NoError(t, s.Release(ctx, "task"))
+			state, _ = s.Acquire(ctx, "task", time.Minute)
+			assert.Equal(t, scheduler.DedupAcquired, state)
+
+			require.NoError(t, s.Complete(ctx, "task", time.Hour))
+			require.NoError(t, s.Release(ctx, "task"))
+			assert.ErrorIs(t, s.Renew(ctx, "task", time.Minute), scheduler.ErrDedupLeaseLost)
+			state, _ = s.Acquire(ctx, "task", time.Minute)
+			assert.Equal(t, scheduler.DedupCompleted, state, "completed records are not released")
+
+			now = now.Add(time.Hour)
+			state, _ = s.Acquire(ctx, "task", time.Minute)
+			assert.Equal(t, scheduler.DedupAcquired, state, "completed records expire after retention")
+		})
+	}
+}
+
+func TestFileDedupStore_reopen(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "dedup.json")
+	s, err := scheduler.OpenFileDedupStore(path)
+	require.NoError(t, err)
+	_, err = s.Acquire(ctx, "task", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, s.Complete(ctx, "task", time.Hour))
+	_, err = s.Acquire(ctx, "released", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, s.Release(ctx, "released"))
+	require.NoError(t, s.Close())
+
+	// a line cut by a crash is dropped
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"key":"partial","compl`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = scheduler.OpenFileDedupStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+	state, err := s.Acquire(ctx, "task", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, scheduler.DedupCompleted, state)
+	state, err = s.Acquire(ctx, "released", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, scheduler.DedupAcquired, state)
+	state, err = s.Acquire(ctx, "partial", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, scheduler.DedupAcquired, state)
+}
+
+func TestFileDedupStore_corrupted(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "dedup.json")
+	require.NoError(t, os.WriteFile(path, []byte("{\"key\":\n"), 0o600))
+
+	_, err := scheduler.OpenFileDedupStore(path)
+	assert.Error(t, err)
+}
+
+func TestFileDedupStore_compaction(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "dedup.json")
+	s, err := scheduler.OpenFileDedupStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+	now := time.Now()
+	s.SetNow(func() time.Time { return now })
+	s.SetMinCompaction(4)
+
+	require.NoError(t, s.Complete(ctx, "old", time.Minute))
+	now = now.Add(time.Hour)
+	require.NoError(t, s.Complete(ctx, "new", time.Hour))
+	for i := 0; i < 10; i++ {
+		_, err := s.Acquire(ctx, "task", time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, s.Release(ctx, "task"))
+	}
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, bytes.Count(b, []byte("\n")), 4, "the log is compacted")
+	assert.NotContains(t, string(b), `"old"`, "expired records are dropped from the file")
+	assert.Contains(t, string(b), `"new"`)
+
+	// appends go to the compacted file
+	require.NoError(t, s.Complete(ctx, "task", time.Hour))
+	s2, err := scheduler.OpenFileDedupStore(path)
+	require.NoError(t, err)
+	defer s2.Close()
+	s2.SetNow(func() time.Time { return now })
+	state, err := s2.Acquire(ctx, "task", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, scheduler.DedupCompleted, state)
+}
+
+func TestDeduplicator_Handler_renew(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	var calls int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// only the first delivery blocks, so that a lost lease fails the test instead of hanging it
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+		}
+	})
+	lease := 100 * time.Millisecond
+	h := scheduler.NewReceiver("test_").Handler(
+		scheduler.NewDeduplicator(scheduler.NewMemoryDedupStore(), scheduler.WithDedupLease(lease)).Handler(next),
+	)
+	deliver := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("X-CloudTasks-TaskName", "test_id_3b9aca00v1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	done := make(chan int)
+	go func() { done <- deliver() }()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(3 * lease)
+	assert.Equal(t, http.StatusConflict, deliver(), "the lease is renewed while the handler runs")
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestDeduplicator_Handler(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	release := make(chan struct{})
+	fail := int32(1)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		if atomic.CompareAndSwapInt32(&fail, 1, 0) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	h := scheduler.NewReceiver("test_").Handler(scheduler.NewDeduplicator(scheduler.NewMemoryDedupStore()).Handler(next))
+
+	deliver := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("X-CloudTasks-TaskName", "test_id_3b9aca00v1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// the first delivery fails while a duplicate arrives
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var first int
+	go func() {
+		defer wg.Done()
+		first = deliver()
+	}()
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, http.StatusConflict, deliver())
+	release <- struct{}{}
+	wg.Wait()
+	assert.Equal(t, http.StatusInternalServerError, first)
+
+	// the retry succeeds
+	go func() { release <- struct{}{} }()
+	assert.Equal(t, http.StatusOK, deliver())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// later duplicates are acknowledged without calling the handler
+	assert.Equal(t, http.StatusOK, deliver())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -1,6 +1,8 @@
 package scheduler
 
 import (
+	"time"
+
 	"github.com/googleapis/gax-go/v2"
 )
 
@@ -11,3 +13,23 @@ func (s *Scheduler) SetIterator(f func(...gax.CallOption) *Iterator) {
 func (i *Iterator) SetLister(lister TaskLister) {
 	i.lister = lister
 }
+
+func (s *MemoryDedupStore) SetNow(now func() time.Time) {
+	s.now = now
+}
+
+func (s *FileDedupStore) SetNow(now func() time.Time) {
+	s.now = now
+}
+
+func (s *FileDedupStore) SetMinCompaction(n int) {
+	s.minCompaction = n
+}
+
+func (s *JWKSKeySource) SetNow(now func() time.Time) {
+	s.now = now
+}
+
+func (q *QueueVersionSource) SetNow(now func() time.Time) {
+	q.now = now
+}
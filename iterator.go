@@ -6,15 +6,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"iter"
 	"strings"
+	"time"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	"github.com/googleapis/gax-go/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/api/iterator"
 	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
 )
 
-var Done = iterator.Done
+var (
+	Done            = iterator.Done
+	ErrTooManyTasks = errors.New("too many tasks")
+)
 
 type TaskLister interface {
 	ListTasks(ctx context.Context, req *taskspb.ListTasksRequest, opts ...gax.CallOption) TaskIterator
@@ -34,61 +41,453 @@ type TaskIterator interface {
 type Iterator struct {
 	lister       TaskLister
 	iter         TaskIterator
+	pageInfo     *iterator.PageInfo
+	listing      bool
+	peeked       *taskspb.Task
+	peekedStart  string
+	peekedErr    error
+	pageLen      int
+	pageRead     int
 	opts         []gax.CallOption
 	queuePath    string
 	taskIDPrefix string
 	pageToken    string
+	pageStart    string
+	pageSize     int32
+
+	fullView  bool
+	filters   []TaskFilter
+	before    time.Time
+	ordered   bool
+	stopped   bool
+	exhausted bool
+
+	malformed MalformedTaskPolicy
+
+	prefetch int
+	prefetcher
+
+	tracer   trace.Tracer
+	pageSpan trace.Span
+	listed   int
+	metrics  Metrics
 }
 
+// maxPageSize is the maximum page size of ListTasks.
+const maxPageSize = 1000
+
 func NewIterator(t TaskLister, queuePath, prefix string, opts ...gax.CallOption) *Iterator {
+	return NewIteratorFromPageToken(t, queuePath, prefix, "", opts...)
+}
+
+// NewIteratorFromPageToken returns an iterator which starts from the page of pageToken,
+// such as the one returned by Iterator.PageToken or Iterator.NextPage.
+func NewIteratorFromPageToken(t TaskLister, queuePath, prefix, pageToken string, opts ...gax.CallOption) *Iterator {
 	return &Iterator{
 		lister:       t,
 		opts:         opts,
 		queuePath:    queuePath,
 		taskIDPrefix: prefix,
+		pageToken:    pageToken,
+		pageStart:    pageToken,
+		pageSize:     maxPageSize,
+	}
+}
+
+// WithPageSize sets the maximum number of tasks listed in a page.
+// The default is 1000, which is the maximum of Cloud Tasks, and larger sizes are clamped to it.
+func (i *Iterator) WithPageSize(n int) *Iterator {
+	if n > 0 {
+		i.pageSize = int32(min(n, maxPageSize))
+	}
+	return i
+}
+
+// WithFullView makes the iterator list tasks with request bodies, which need cloudtasks.tasks.fullView permission.
+func (i *Iterator) WithFullView() *Iterator {
+	i.fullView = true
+	return i
+}
+
+// AuthorizationType is the kind of Task.Authorization.
+type AuthorizationType int
+
+const (
+	AuthorizationNone AuthorizationType = iota
+	AuthorizationOIDC
+	AuthorizationOAuth
+)
+
+func authorizationType(t *Task) AuthorizationType {
+	switch t.Authorization.(type) {
+	case *OIDCToken:
+		return AuthorizationOIDC
+	case *OAuthToken:
+		return AuthorizationOAuth
+	default:
+		return AuthorizationNone
+	}
+}
+
+// WithFilter makes the iterator return only the tasks selected by filter.
+// Filters are combined by AND.
+func (i *Iterator) WithFilter(filter TaskFilter) *Iterator {
+	if filter != nil {
+		i.filters = append(i.filters, filter)
+	}
+	return i
+}
+
+// WithIDFilter makes the iterator return only the tasks whose id is selected by f.
+func (i *Iterator) WithIDFilter(f func(id string) bool) *Iterator {
+	return i.WithFilter(func(t *Task) bool { return f(t.ID) })
+}
+
+// WithScheduledBetween makes the iterator return only the tasks scheduled in [from, to).
+// A zero from or to leaves the range unbounded on that side.
+// Multiple calls narrow the range to the intersection of the ranges.
+func (i *Iterator) WithScheduledBetween(from, to time.Time) *Iterator {
+	if !to.IsZero() && (i.before.IsZero() || to.Before(i.before)) {
+		i.before = to
+	}
+	return i.WithFilter(func(t *Task) bool {
+		return (from.IsZero() || !t.ScheduledAt.Before(from)) && (to.IsZero() || t.ScheduledAt.Before(to))
+	})
+}
+
+// WithAuthorizationType makes the iterator return only the tasks with one of the authorization types.
+func (i *Iterator) WithAuthorizationType(types ...AuthorizationType) *Iterator {
+	return i.WithFilter(func(t *Task) bool {
+		at := authorizationType(t)
+		for _, typ := range types {
+			if at == typ {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// WithScheduleTimeOrder tells the iterator that the lister returns tasks ordered by schedule time,
+// so that the iteration stops at the first task after the range of WithScheduledBetween.
+// Cloud Tasks doesn't guarantee any order, so this is only for listers which do.
+func (i *Iterator) WithScheduleTimeOrder() *Iterator {
+	i.ordered = true
+	return i
+}
+
+// MalformedTaskPolicy decides what an Iterator does with a task under the prefix which can't be converted,
+// such as one with an unparsable name or a non-HTTP target.
+// Returning nil skips the task, and returning an error aborts the iteration with it.
+type MalformedTaskPolicy func(name string, err error) error
+
+// FailOnMalformedTask is the default MalformedTaskPolicy, which aborts the iteration.
+func FailOnMalformedTask(_ string, err error) error {
+	return err
+}
+
+// SkipMalformedTask is a MalformedTaskPolicy which skips malformed tasks silently.
+func SkipMalformedTask(string, error) error {
+	return nil
+}
+
+// ReportMalformedTask returns a MalformedTaskPolicy which skips malformed tasks after reporting them to report.
+func ReportMalformedTask(report func(name string, err error)) MalformedTaskPolicy {
+	return func(name string, err error) error {
+		report(name, err)
+		return nil
+	}
+}
+
+// WithMalformedTaskPolicy sets the policy for malformed tasks. The default is FailOnMalformedTask.
+func (i *Iterator) WithMalformedTaskPolicy(policy MalformedTaskPolicy) *Iterator {
+	i.malformed = policy
+	return i
+}
+
+func (i *Iterator) match(t *Task) bool {
+	for _, f := range i.filters {
+		if !f(t) {
+			return false
+		}
 	}
+	return true
 }
 
 func (i *Iterator) Next(ctx context.Context) (*Task, error) {
+	if i.prefetch > 0 {
+		return i.nextPrefetched(ctx)
+	}
+
+	for {
+		task, _, err := i.nextListed(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		t, ok, err := i.convert(ctx, task)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return t, nil
+		}
+	}
+}
+
+// NextPage returns the matched tasks of the next page of the listing and the token of the following page.
+// The page can be empty if none of its tasks are matched, and the token is empty on the last page.
+// It returns Done if there are no more pages.
+// Unless the lister is the Cloud Tasks client, which reports the size of a page,
+// the end of a page is found when the following page is fetched, so NextPage fetches a page ahead.
+func (i *Iterator) NextPage(ctx context.Context) ([]*Task, string, error) {
+	if i.prefetch > 0 {
+		return i.nextPrefetchedPage(ctx)
+	}
+
+	return i.fetchPage(ctx)
+}
+
+// fetchPage returns the tasks of the next page.
+// The end of the page is found by reading the first task of the following page, which is kept for the next call.
+func (i *Iterator) fetchPage(ctx context.Context) ([]*Task, string, error) {
+	tasks := []*Task{}
+	for n := 0; ; n++ {
+		start := i.pageStart
+		task, newPage, err := i.nextListed(ctx)
+		if errors.Is(err, Done) && n > 0 {
+			return tasks, "", nil
+		}
+		if err != nil && n > 0 {
+			// the error of fetching the following page is returned by the next call
+			i.peekedErr = err
+			return tasks, i.pageInfo.Token, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if newPage && n > 0 {
+			i.peeked, i.peekedStart, i.pageStart = task, i.pageStart, start
+			return tasks, i.peekedStart, nil
+		}
+
+		t, ok, err := i.convert(ctx, task)
+		if errors.Is(err, Done) {
+			return tasks, "", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if ok {
+			tasks = append(tasks, t)
+		}
+		if i.pageLen > 0 && i.pageRead == i.pageLen {
+			return tasks, i.pageInfo.Token, nil
+		}
+	}
+}
+
+// PageToken returns the token of the page of the task returned last,
+// which NewIteratorFromPageToken resumes the iteration from.
+// The tasks of the page which have already been returned are returned again after resuming.
+// ok is false if the iteration has returned Done at the end of the listing, so that there is nothing to resume;
+// the empty token of the first page is returned with true.
+func (i *Iterator) PageToken() (token string, ok bool) {
+	if i.prefetch > 0 {
+		return i.consumedStart, !i.drained
+	}
+	return i.pageStart, !i.exhausted
+}
+
+// nextListed returns the next listed task, listing the next tasks if needed,
+// and reports whether the task is the first one of a page.
+func (i *Iterator) nextListed(ctx context.Context) (*taskspb.Task, bool, error) {
+	if err := i.peekedErr; err != nil {
+		i.peekedErr = nil
+		return nil, false, err
+	}
+	if i.peeked != nil {
+		task := i.peeked
+		i.peeked, i.pageStart = nil, i.peekedStart
+		return task, true, nil
+	}
+	if i.stopped {
+		return nil, false, Done
+	}
 	if i.iter == nil {
 		i.listTasks(ctx)
 	}
 
 	for {
-		task, err := i.iter.Next()
+		task, newPage, err := i.nextPbTask(ctx)
 		if errors.Is(err, iterator.Done) {
+			i.endPageSpan(nil)
+			i.pageToken = i.pageInfo.Token
 			if i.pageToken == "" {
-				return nil, Done
+				i.stopped, i.exhausted = true, true
+				return nil, false, Done
 			}
 			i.listTasks(ctx)
 			continue
 		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to iterate: %w", err)
+			i.endPageSpan(err)
+			return nil, false, fmt.Errorf("failed to iterate: %w", err)
 		}
+		i.listed++
 
-		// ignore task which has unmatched prefix
-		taskNamePrefix := taskName(i.queuePath, i.taskIDPrefix)
-		if !strings.HasPrefix(task.Name, taskNamePrefix) {
-			continue
+		return task, newPage, nil
+	}
+}
+
+// convert converts task, and reports whether it is under the prefix and matched by the filters.
+// It returns Done if the iteration can stop at task.
+func (i *Iterator) convert(ctx context.Context, task *taskspb.Task) (*Task, bool, error) {
+	// ignore task which has unmatched prefix
+	taskNamePrefix := taskName(i.queuePath, i.taskIDPrefix)
+	if !strings.HasPrefix(task.Name, taskNamePrefix) {
+		return nil, false, nil
+	}
+
+	if i.ordered && !i.before.IsZero() && !task.GetScheduleTime().AsTime().Before(i.before) {
+		i.endPageSpan(nil)
+		i.stopped, i.exhausted = true, true
+		return nil, false, Done
+	}
+
+	t, err := PbTaskToTask(ctx, i.queuePath, i.taskIDPrefix, task)
+	if err != nil {
+		err = fmt.Errorf("failed to convert task from pbtask: %w", err)
+		if i.malformed != nil {
+			err = i.malformed(task.Name, err)
+		}
+		return nil, false, err
+	}
+
+	return t, i.match(t), nil
+}
+
+// All returns the remaining tasks as an iterator to range over.
+// The iteration ends at the first error, which is yielded with a nil task.
+// The iterator is closed when the loop ends.
+func (i *Iterator) All(ctx context.Context) iter.Seq2[*Task, error] {
+	return func(yield func(*Task, error) bool) {
+		defer i.Close()
+		for {
+			t, err := i.Next(ctx)
+			if errors.Is(err, Done) {
+				return
+			}
+			if !yield(t, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Pages returns the remaining tasks page by page as an iterator to range over.
+// Pages without matched tasks are skipped.
+// The iteration ends at the first error, which is yielded with a nil page.
+// The iterator is closed when the loop ends.
+func (i *Iterator) Pages(ctx context.Context) iter.Seq2[[]*Task, error] {
+	return func(yield func([]*Task, error) bool) {
+		defer i.Close()
+		for {
+			tasks, _, err := i.NextPage(ctx)
+			if errors.Is(err, Done) {
+				return
+			}
+			if err == nil && len(tasks) == 0 {
+				continue
+			}
+			if !yield(tasks, err) || err != nil {
+				return
+			}
 		}
+	}
+}
 
-		t, err := PbTaskToTask(ctx, i.queuePath, i.taskIDPrefix, task)
+// Collect returns the tasks of seq.
+// It returns ErrTooManyTasks if seq has more than max tasks. A max of 0 or less means no limit.
+func Collect(seq iter.Seq2[*Task, error], max int) ([]*Task, error) {
+	var tasks []*Task
+	for t, err := range seq {
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert task from pbtask: %w", err)
+			return nil, err
 		}
+		if max > 0 && len(tasks) == max {
+			return nil, fmt.Errorf("more than %d tasks: %w", max, ErrTooManyTasks)
+		}
+		tasks = append(tasks, t)
+	}
 
-		return t, nil
+	return tasks, nil
+}
+
+// nextPbTask returns the next task of the current list, and reports whether a page was fetched for it,
+// keeping the token of the page and recording the latency of the page fetch if any.
+// A page is fetched by the first call of a list, and by the calls which change the token of the next page.
+func (i *Iterator) nextPbTask(ctx context.Context) (*taskspb.Task, bool, error) {
+	token, first := i.pageInfo.Token, i.listing
+	i.listing = false
+	start := time.Now()
+	task, err := i.iter.Next()
+	fetched := first || i.pageInfo.Token != token
+	if fetched && err == nil {
+		i.pageStart, i.pageLen, i.pageRead = token, i.fetchedLen(), 0
+	}
+	i.pageRead++
+	if i.metrics != nil && (fetched || err != nil) && !errors.Is(err, iterator.Done) {
+		i.metrics.RecordRPC(ctx, MetricLabels{Queue: i.queuePath, Prefix: i.taskIDPrefix}, "ListTasks", time.Since(start), err)
+	}
+
+	return task, fetched && err == nil, err
+}
+
+// fetchedLen returns the number of tasks of the page fetched last, or 0 if it is unknown.
+// Only the iterators of the Cloud Tasks client keep the response of the page.
+func (i *Iterator) fetchedLen() int {
+	if it, ok := i.iter.(*cloudtasks.TaskIterator); ok {
+		if resp, ok := it.Response.(*taskspb.ListTasksResponse); ok {
+			return len(resp.GetTasks())
+		}
 	}
+	return 0
 }
 
 func (i *Iterator) listTasks(ctx context.Context) {
+	view := taskspb.Task_BASIC
+	if i.fullView {
+		view = taskspb.Task_FULL
+	}
 	req := &taskspb.ListTasksRequest{
 		Parent:       i.queuePath,
-		ResponseView: taskspb.Task_BASIC,
-		PageSize:     1000,
+		ResponseView: view,
+		PageSize:     i.pageSize,
 		PageToken:    i.pageToken,
 	}
+	if i.tracer != nil {
+		ctx, i.pageSpan = startSpan(ctx, i.tracer, "scheduler.ListTasks",
+			AttributeQueue.String(i.queuePath),
+			AttributePrefix.String(i.taskIDPrefix),
+		)
+		i.listed = 0
+	}
 	i.iter = i.lister.ListTasks(ctx, req, i.opts...)
-	i.pageToken = i.iter.PageInfo().Token
+	// the page info is shared by the pages of the list, and its token is updated on every fetch
+	i.pageInfo, i.listing = i.iter.PageInfo(), true
+}
+
+func (i *Iterator) endPageSpan(err error) {
+	if i.pageSpan == nil {
+		return
+	}
+
+	i.pageSpan.SetAttributes(attribute.Int("scheduler.list.tasks", i.listed))
+	outcome := OutcomeOK
+	if err != nil {
+		outcome = OutcomeError
+	}
+	endSpan(i.pageSpan, outcome, err)
+	i.pageSpan = nil
 }
@@ -0,0 +1,131 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// RedactionPolicy decides which parts of task requests are logged.
+// The zero value omits bodies and redacts headers matched by DefaultRedactHeader.
+// Query values and user info of URLs are always redacted since they often carry credentials.
+type RedactionPolicy struct {
+	// IncludeBody logs request bodies.
+	IncludeBody bool
+	// RedactHeader reports whether the value of the header is replaced with "REDACTED".
+	// nil means DefaultRedactHeader.
+	RedactHeader func(name string) bool
+}
+
+var sensitiveHeaderWords = []string{"auth", "cookie", "token", "secret", "key", "signature", "password", "credential"}
+
+// DefaultRedactHeader matches Authorization-like headers such as Authorization, Cookie and X-Api-Key.
+func DefaultRedactHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, w := range sensitiveHeaderWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithLogger makes Scheduler log planned and executed mutations to l.
+// Executed mutations are logged at info level, failures at error level and plans at debug level.
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Scheduler) {
+		s.logger = l
+	}
+}
+
+// WithRedactionPolicy sets the policy of logged task requests.
+func WithRedactionPolicy(p RedactionPolicy) Option {
+	return func(s *Scheduler) {
+		s.redaction = p
+	}
+}
+
+func (s *Scheduler) log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if s.logger == nil || !s.logger.Enabled(ctx, level) {
+		return
+	}
+
+	s.logger.LogAttrs(ctx, level, msg, append([]slog.Attr{
+		slog.String("queue", s.queuePath),
+		slog.String("prefix", s.prefix),
+	}, attrs...)...)
+}
+
+func (s *Scheduler) taskAttr(t *Task) slog.Attr {
+	attrs := []any{
+		slog.String("id", t.ID),
+		slog.Int("version", t.Version),
+		slog.Time("scheduledAt", t.ScheduledAt),
+	}
+	if t.Request != nil {
+		attrs = append(attrs, s.requestAttr(t.Request))
+	}
+
+	return slog.Group("task", attrs...)
+}
+
+func (s *Scheduler) requestAttr(r *http.Request) slog.Attr {
+	redact := s.redaction.RedactHeader
+	if redact == nil {
+		redact = DefaultRedactHeader
+	}
+
+	headers := make([]any, 0, len(r.Header))
+	for k, v := range r.Header {
+		value := strings.Join(v, ",")
+		if redact(k) {
+			value = redacted
+		}
+		headers = append(headers, slog.String(k, value))
+	}
+
+	attrs := []any{
+		slog.String("method", r.Method),
+		slog.String("url", redactURL(r.URL)),
+		slog.Group("headers", headers...),
+	}
+	if s.redaction.IncludeBody && r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			attrs = append(attrs, slog.String("body", string(body)))
+		}
+	}
+
+	return slog.Group("request", attrs...)
+}
+
+// redactURL returns u without the user info and fragment, and with the query values replaced with "REDACTED".
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+
+	r := *u
+	r.User, r.Fragment, r.RawFragment = nil, "", ""
+	if r.RawQuery != "" {
+		query := r.Query()
+		for k := range query {
+			query[k] = []string{redacted}
+		}
+		r.RawQuery = query.Encode()
+	}
+
+	return r.String()
+}
+
+func errorAttr(err error) slog.Attr {
+	return slog.String("error", err.Error())
+}
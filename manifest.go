@@ -0,0 +1,659 @@
+package scheduler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultManifestHorizon      = 7 * 24 * time.Hour
+	defaultManifestMethod       = http.MethodPost
+	maxRecurrenceOccurrences    = 10000
+	manifestEnvDefaultSeparator = ":-"
+)
+
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// ManifestError is an error found in a manifest, reported with the position of the offending node.
+type ManifestError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *ManifestError) Error() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+func (e *ManifestError) Unwrap() error {
+	return ErrInvalidManifest
+}
+
+func manifestErrorf(node *yaml.Node, format string, args ...interface{}) error {
+	return &ManifestError{
+		Line:   node.Line,
+		Column: node.Column,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+type ManifestOption func(*manifestLoader)
+
+// WithManifestNow sets the clock used to expand recurrences.
+func WithManifestNow(now func() time.Time) ManifestOption {
+	return func(l *manifestLoader) {
+		l.now = now
+	}
+}
+
+// WithManifestHorizon sets how far ahead recurrences without "until" are expanded.
+func WithManifestHorizon(d time.Duration) ManifestOption {
+	return func(l *manifestLoader) {
+		l.horizon = d
+	}
+}
+
+// WithManifestLookupEnv sets the function used to resolve ${VAR} references.
+func WithManifestLookupEnv(f func(string) (string, bool)) ManifestOption {
+	return func(l *manifestLoader) {
+		l.lookupEnv = f
+	}
+}
+
+// WithManifestBaseDir sets the directory which relative "bodyFile" paths are resolved against.
+func WithManifestBaseDir(dir string) ManifestOption {
+	return func(l *manifestLoader) {
+		l.baseDir = dir
+	}
+}
+
+type manifestLoader struct {
+	queuePath string
+	prefix    string
+	now       func() time.Time
+	horizon   time.Duration
+	lookupEnv func(string) (string, bool)
+	baseDir   string
+}
+
+// LoadManifest reads the manifest file at path and returns the tasks it declares.
+// Relative body files are resolved against the directory of the manifest.
+func LoadManifest(path, queuePath, prefix string, opts ...ManifestOption) ([]*Task, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	opts = append([]ManifestOption{WithManifestBaseDir(filepath.Dir(path))}, opts...)
+	tasks, err := ParseManifest(data, queuePath, prefix, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return tasks, nil
+}
+
+// ParseManifest parses a YAML or JSON manifest and returns the tasks it declares.
+//
+// A manifest has the following shape:
+//
+//	defaults:
+//	  method: POST
+//	  headers:
+//	    Content-Type: application/json
+//	  auth:
+//	    oidc:
+//	      serviceAccountEmail: invoker@project.iam.gserviceaccount.com
+//	      audience: https://example.com
+//	tasks:
+//	  - id: release
+//	    time: 2022-12-01T12:00:00+09:00
+//	    url: https://example.com/release
+//	    body: '{"version":"1.0.0"}'
+//	recurrences:
+//	  - id: report
+//	    cron: "0 9 * * MON-FRI"
+//	    timezone: Asia/Tokyo
+//	    url: https://example.com/report
+//	    bodyFile: report.json
+//
+// Scalar values may reference environment variables as ${VAR} or ${VAR:-default}; "$$" is a literal "$".
+// Recurrences are expanded from "from" (default: now) until "until" (default: now + horizon), up to "count" occurrences.
+func ParseManifest(data []byte, queuePath, prefix string, opts ...ManifestOption) ([]*Task, error) {
+	l := &manifestLoader{
+		queuePath: queuePath,
+		prefix:    prefix,
+		now:       time.Now,
+		horizon:   defaultManifestHorizon,
+		lookupEnv: os.LookupEnv,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, yamlSyntaxError(err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	return l.load(doc.Content[0])
+}
+
+var yamlLinePattern = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func yamlSyntaxError(err error) error {
+	if m := yamlLinePattern.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return &ManifestError{Line: line, Msg: m[2]}
+	}
+	return fmt.Errorf("failed to parse manifest: %v: %w", err, ErrInvalidManifest)
+}
+
+type manifestRequest struct {
+	Method   string            `yaml:"method"`
+	URL      string            `yaml:"url"`
+	Headers  map[string]string `yaml:"headers"`
+	Body     *string           `yaml:"body"`
+	BodyFile string            `yaml:"bodyFile"`
+	Auth     *manifestAuth     `yaml:"auth"`
+	Version  int               `yaml:"version"`
+}
+
+type manifestAuth struct {
+	OIDC *struct {
+		ServiceAccountEmail string `yaml:"serviceAccountEmail"`
+		Audience            string `yaml:"audience"`
+	} `yaml:"oidc"`
+	OAuth *struct {
+		ServiceAccountEmail string `yaml:"serviceAccountEmail"`
+		Scope               string `yaml:"scope"`
+	} `yaml:"oauth"`
+}
+
+type manifestTask struct {
+	ID              string `yaml:"id"`
+	Time            string `yaml:"time"`
+	manifestRequest `yaml:",inline"`
+}
+
+type manifestRecurrence struct {
+	ID              string `yaml:"id"`
+	Cron            string `yaml:"cron"`
+	Timezone        string `yaml:"timezone"`
+	From            string `yaml:"from"`
+	Until           string `yaml:"until"`
+	Count           int    `yaml:"count"`
+	manifestRequest `yaml:",inline"`
+}
+
+var (
+	manifestKeys        = []string{"defaults", "tasks", "recurrences"}
+	manifestRequestKeys = []string{"method", "url", "headers", "body", "bodyFile", "auth", "version"}
+	manifestTaskKeys    = append([]string{"id", "time"}, manifestRequestKeys...)
+	manifestRecurKeys   = append([]string{"id", "cron", "timezone", "from", "until", "count"}, manifestRequestKeys...)
+	manifestAuthKeys    = []string{"oidc", "oauth"}
+)
+
+func (l *manifestLoader) load(root *yaml.Node) ([]*Task, error) {
+	if err := l.expandEnv(root); err != nil {
+		return nil, err
+	}
+	if err := checkManifestKeys(root, manifestKeys); err != nil {
+		return nil, err
+	}
+
+	var defaults manifestRequest
+	if n := mappingValue(root, "defaults"); n != nil {
+		if err := checkManifestKeys(n, manifestRequestKeys); err != nil {
+			return nil, err
+		}
+		if err := decodeManifestNode(n, &defaults); err != nil {
+			return nil, err
+		}
+	}
+
+	var tasks []*Task
+	seen := make(map[string]bool)
+	add := func(n *yaml.Node, t *Task) error {
+		if seen[t.comparisonID()] {
+			return manifestErrorf(n, "duplicate task %s at %s", t.ID, t.ScheduledAt.Format(time.RFC3339))
+		}
+		seen[t.comparisonID()] = true
+		tasks = append(tasks, t)
+		return nil
+	}
+
+	taskNodes, err := sequenceItems(mappingValue(root, "tasks"))
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range taskNodes {
+		if err := checkManifestKeys(n, manifestTaskKeys); err != nil {
+			return nil, err
+		}
+		var mt manifestTask
+		if err := decodeManifestNode(n, &mt); err != nil {
+			return nil, err
+		}
+		if mt.Time == "" {
+			return nil, manifestErrorf(n, "time is required")
+		}
+		scheduledAt, err := time.Parse(time.RFC3339, mt.Time)
+		if err != nil {
+			return nil, manifestErrorf(fieldNode(n, "time"), "invalid time %q: must be RFC3339", mt.Time)
+		}
+
+		t, err := l.task(n, mt.ID, scheduledAt, &mt.manifestRequest, &defaults)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(n, t); err != nil {
+			return nil, err
+		}
+	}
+
+	recurrenceNodes, err := sequenceItems(mappingValue(root, "recurrences"))
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range recurrenceNodes {
+		if err := checkManifestKeys(n, manifestRecurKeys); err != nil {
+			return nil, err
+		}
+		var mr manifestRecurrence
+		if err := decodeManifestNode(n, &mr); err != nil {
+			return nil, err
+		}
+
+		times, err := l.occurrences(n, &mr)
+		if err != nil {
+			return nil, err
+		}
+		for _, scheduledAt := range times {
+			t, err := l.task(n, mr.ID, scheduledAt, &mr.manifestRequest, &defaults)
+			if err != nil {
+				return nil, err
+			}
+			if err := add(n, t); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return tasks, nil
+}
+
+func (l *manifestLoader) occurrences(n *yaml.Node, mr *manifestRecurrence) ([]time.Time, error) {
+	if mr.Cron == "" {
+		return nil, manifestErrorf(n, "cron is required")
+	}
+
+	spec := mr.Cron
+	if mr.Timezone != "" {
+		if _, err := time.LoadLocation(mr.Timezone); err != nil {
+			return nil, manifestErrorf(fieldNode(n, "timezone"), "unknown timezone %q", mr.Timezone)
+		}
+		spec = "CRON_TZ=" + mr.Timezone + " " + spec
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, manifestErrorf(fieldNode(n, "cron"), "invalid cron expression %q: %v", mr.Cron, err)
+	}
+
+	now := l.now()
+	from := now
+	if mr.From != "" {
+		if from, err = time.Parse(time.RFC3339, mr.From); err != nil {
+			return nil, manifestErrorf(fieldNode(n, "from"), "invalid from %q: must be RFC3339", mr.From)
+		}
+		if from.Before(now) {
+			from = now
+		}
+	}
+	until := now.Add(l.horizon)
+	if mr.Until != "" {
+		if until, err = time.Parse(time.RFC3339, mr.Until); err != nil {
+			return nil, manifestErrorf(fieldNode(n, "until"), "invalid until %q: must be RFC3339", mr.Until)
+		}
+	}
+	if mr.Count < 0 {
+		return nil, manifestErrorf(fieldNode(n, "count"), "count must not be negative")
+	}
+
+	var times []time.Time
+	// cron.Schedule.Next returns the first activation strictly after the given time.
+	for t := schedule.Next(from.Add(-time.Nanosecond)); !t.IsZero() && !t.After(until); t = schedule.Next(t) {
+		if mr.Count > 0 && len(times) >= mr.Count {
+			break
+		}
+		if len(times) >= maxRecurrenceOccurrences {
+			return nil, manifestErrorf(n, "recurrence expands to more than %d occurrences", maxRecurrenceOccurrences)
+		}
+		times = append(times, t)
+	}
+
+	return times, nil
+}
+
+func (l *manifestLoader) task(n *yaml.Node, id string, scheduledAt time.Time, mr, defaults *manifestRequest) (*Task, error) {
+	if id == "" {
+		return nil, manifestErrorf(n, "id is required")
+	}
+
+	method := mr.Method
+	if method == "" {
+		method = defaults.Method
+	}
+	if method == "" {
+		method = defaultManifestMethod
+	}
+	method = strings.ToUpper(method)
+	if v, ok := taskspb.HttpMethod_value[method]; !ok || v == int32(taskspb.HttpMethod_HTTP_METHOD_UNSPECIFIED) {
+		return nil, manifestErrorf(fieldNode(n, "method"), "unsupported method %q", method)
+	}
+
+	rawURL := mr.URL
+	if rawURL == "" {
+		rawURL = defaults.URL
+	}
+	if rawURL == "" {
+		return nil, manifestErrorf(n, "url is required")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, manifestErrorf(fieldNode(n, "url"), "invalid url %q: must be an absolute http(s) url", rawURL)
+	}
+
+	body, err := l.body(n, mr, defaults)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u.String(), r)
+	if err != nil {
+		return nil, manifestErrorf(n, "failed to build request: %v", err)
+	}
+	for k, v := range defaults.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range mr.Headers {
+		req.Header.Set(k, v)
+	}
+
+	auth := mr.Auth
+	authNode := fieldNode(n, "auth")
+	if auth == nil {
+		auth = defaults.Auth
+		authNode = n
+	}
+	authorization, err := manifestAuthorization(authNode, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	version := mr.Version
+	if version == 0 {
+		version = defaults.Version
+	}
+	if version < 0 {
+		return nil, manifestErrorf(fieldNode(n, "version"), "version must not be negative")
+	}
+	if version == 0 {
+		version = 1
+	}
+
+	t := &Task{
+		QueuePath:     l.queuePath,
+		Prefix:        l.prefix,
+		ID:            id,
+		ScheduledAt:   scheduledAt,
+		Request:       req,
+		Authorization: authorization,
+		Version:       version,
+	}
+	if err := t.Validate(); err != nil {
+		return nil, manifestErrorf(fieldNode(n, "id"), "%v", err)
+	}
+
+	return t, nil
+}
+
+func (l *manifestLoader) body(n *yaml.Node, mr, defaults *manifestRequest) ([]byte, error) {
+	if mr.Body != nil && mr.BodyFile != "" {
+		return nil, manifestErrorf(fieldNode(n, "bodyFile"), "body and bodyFile are mutually exclusive")
+	}
+
+	switch {
+	case mr.Body != nil:
+		return []byte(*mr.Body), nil
+	case mr.BodyFile != "":
+		return l.readBodyFile(fieldNode(n, "bodyFile"), mr.BodyFile)
+	case defaults.Body != nil:
+		return []byte(*defaults.Body), nil
+	case defaults.BodyFile != "":
+		return l.readBodyFile(n, defaults.BodyFile)
+	}
+
+	return nil, nil
+}
+
+func (l *manifestLoader) readBodyFile(n *yaml.Node, name string) ([]byte, error) {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(l.baseDir, name)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, manifestErrorf(n, "failed to read body file: %v", err)
+	}
+	return b, nil
+}
+
+func manifestAuthorization(n *yaml.Node, auth *manifestAuth) (isAuthorizationToken, error) {
+	if auth == nil {
+		return nil, nil
+	}
+	if auth.OIDC != nil && auth.OAuth != nil {
+		return nil, manifestErrorf(n, "auth must have either oidc or oauth")
+	}
+
+	switch {
+	case auth.OIDC != nil:
+		if auth.OIDC.ServiceAccountEmail == "" {
+			return nil, manifestErrorf(n, "auth.oidc.serviceAccountEmail is required")
+		}
+		return &OIDCToken{
+			ServiceAccountEmail: auth.OIDC.ServiceAccountEmail,
+			Audience:            auth.OIDC.Audience,
+		}, nil
+	case auth.OAuth != nil:
+		if auth.OAuth.ServiceAccountEmail == "" {
+			return nil, manifestErrorf(n, "auth.oauth.serviceAccountEmail is required")
+		}
+		return &OAuthToken{
+			ServiceAccountEmail: auth.OAuth.ServiceAccountEmail,
+			Scope:               auth.OAuth.Scope,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// expandEnv substitutes environment variable references in every scalar value of the tree.
+func (l *manifestLoader) expandEnv(n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		v, err := l.expandEnvString(n.Value)
+		if err != nil {
+			return manifestErrorf(n, "%v", err)
+		}
+		// a plain scalar is resolved again as if the value had been written,
+		// so that a reference can set a field other than a string
+		if v != n.Value && n.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			n.Tag = ""
+		}
+		n.Value = v
+	case yaml.MappingNode:
+		// keys are not expanded
+		for i := 1; i < len(n.Content); i += 2 {
+			if err := l.expandEnv(n.Content[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, c := range n.Content {
+			if err := l.expandEnv(c); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *manifestLoader) expandEnvString(s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			expr := s[i+2 : i+end]
+			name, def, hasDefault := expr, "", false
+			if idx := strings.Index(expr, manifestEnvDefaultSeparator); idx >= 0 {
+				name, def, hasDefault = expr[:idx], expr[idx+len(manifestEnvDefaultSeparator):], true
+			}
+			if name == "" {
+				return "", fmt.Errorf("empty variable name in %q", s)
+			}
+			v, ok := l.lookupEnv(name)
+			if !ok || (v == "" && hasDefault) {
+				if !hasDefault {
+					return "", fmt.Errorf("environment variable %s is not set", name)
+				}
+				v = def
+			}
+			b.WriteString(v)
+			i += end
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String(), nil
+}
+
+func checkManifestKeys(n *yaml.Node, allowed []string) error {
+	if n.Kind != yaml.MappingNode {
+		return manifestErrorf(n, "expected a mapping")
+	}
+
+	for i := 0; i < len(n.Content); i += 2 {
+		key := n.Content[i]
+		known := false
+		for _, a := range allowed {
+			if key.Value == a {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return manifestErrorf(key, "unknown field %q", key.Value)
+		}
+		if key.Value == "auth" {
+			v := n.Content[i+1]
+			if v.Kind == yaml.MappingNode {
+				if err := checkManifestKeys(v, manifestAuthKeys); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func decodeManifestNode(n *yaml.Node, v interface{}) error {
+	if err := n.Decode(v); err != nil {
+		var te *yaml.TypeError
+		if errors.As(err, &te) && len(te.Errors) > 0 {
+			if m := yamlLinePattern.FindStringSubmatch("yaml: " + te.Errors[0]); m != nil {
+				line, _ := strconv.Atoi(m[1])
+				return &ManifestError{Line: line, Msg: m[2]}
+			}
+		}
+		return manifestErrorf(n, "%v", err)
+	}
+
+	return nil
+}
+
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+func sequenceItems(n *yaml.Node) ([]*yaml.Node, error) {
+	if n == nil {
+		return nil, nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		return nil, manifestErrorf(n, "expected a sequence")
+	}
+
+	return n.Content, nil
+}
+
+// fieldNode returns the value node of key in n, or n itself when the key is absent.
+func fieldNode(n *yaml.Node, key string) *yaml.Node {
+	if v := mappingValue(n, key); v != nil {
+		return v
+	}
+
+	return n
+}
@@ -0,0 +1,264 @@
+package scheduler_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oss/scheduler"
+)
+
+func TestParseManifest(t *testing.T) {
+	t.Parallel()
+
+	const queuePath = "projects/tokyo-rain-123/locations/asia-northeast1/queues/scheduler"
+	now := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	env := map[string]string{
+		"HOST":  "example.com",
+		"EMAIL": "invoker@example.com",
+		"COUNT": "2",
+	}
+	lookupEnv := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	type want struct {
+		id          string
+		scheduledAt time.Time
+		method      string
+		url         string
+		header      http.Header
+		body        string
+		auth        interface{}
+		version     int
+	}
+	tests := []struct {
+		name     string
+		manifest string
+		want     []want
+		wantErr  *scheduler.ManifestError
+	}{
+		{
+			name: "task with defaults",
+			manifest: `
+defaults:
+  headers:
+    Content-Type: application/json
+  auth:
+    oidc:
+      serviceAccountEmail: ${EMAIL}
+      audience: https://${HOST}
+tasks:
+  - id: release
+    time: 2022-12-01T12:00:00Z
+    url: https://${HOST}/release
+    body: '{"version":"1.0.0"}'
+    version: 2
+`,
+			want: []want{
+				{
+					id:          "release",
+					scheduledAt: time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC),
+					method:      http.MethodPost,
+					url:         "https://example.com/release",
+					header:      http.Header{"Content-Type": {"application/json"}},
+					body:        `{"version":"1.0.0"}`,
+					auth: &scheduler.OIDCToken{
+						ServiceAccountEmail: "invoker@example.com",
+						Audience:            "https://example.com",
+					},
+					version: 2,
+				},
+			},
+		},
+		{
+			name: "recurrence",
+			manifest: `
+recurrences:
+  - id: report
+    cron: "0 9 * * *"
+    method: get
+    url: https://example.com/report?q=${QUERY:-daily}
+    count: ${COUNT:-3}
+    version: ${VERSION:-2}
+    auth:
+      oauth:
+        serviceAccountEmail: invoker@example.com
+        scope: https://www.googleapis.com/auth/cloud-platform
+`,
+			want: []want{
+				{
+					id:          "report",
+					scheduledAt: time.Date(2022, 12, 1, 9, 0, 0, 0, time.UTC),
+					method:      http.MethodGet,
+					url:         "https://example.com/report?q=daily",
+					header:      http.Header{},
+					auth: &scheduler.OAuthToken{
+						ServiceAccountEmail: "invoker@example.com",
+						Scope:               "https://www.googleapis.com/auth/cloud-platform",
+					},
+					version: 2,
+				},
+				{
+					id:          "report",
+					scheduledAt: time.Date(2022, 12, 2, 9, 0, 0, 0, time.UTC),
+					method:      http.MethodGet,
+					url:         "https://example.com/report?q=daily",
+					header:      http.Header{},
+					auth: &scheduler.OAuthToken{
+						ServiceAccountEmail: "invoker@example.com",
+						Scope:               "https://www.googleapis.com/auth/cloud-platform",
+					},
+					version: 2,
+				},
+			},
+		},
+		{
+			name:     "json manifest",
+			manifest: `{"tasks": [{"id": "json", "time": "2022-12-01T12:00:00Z", "url": "https://example.com/"}]}`,
+			want: []want{
+				{
+					id:          "json",
+					scheduledAt: time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC),
+					method:      http.MethodPost,
+					url:         "https://example.com/",
+					header:      http.Header{},
+					version:     1,
+				},
+			},
+		},
+		{
+			name: "unknown field",
+			manifest: `
+tasks:
+  - id: release
+    time: 2022-12-01T12:00:00Z
+    uri: https://example.com/
+`,
+			wantErr: &scheduler.ManifestError{Line: 5, Column: 5, Msg: `unknown field "uri"`},
+		},
+		{
+			name: "invalid time",
+			manifest: `
+tasks:
+  - id: release
+    time: tomorrow
+    url: https://example.com/
+`,
+			wantErr: &scheduler.ManifestError{Line: 4, Column: 11, Msg: `invalid time "tomorrow": must be RFC3339`},
+		},
+		{
+			name: "unset environment variable",
+			manifest: `
+tasks:
+  - id: release
+    time: 2022-12-01T12:00:00Z
+    url: https://${UNKNOWN}/
+`,
+			wantErr: &scheduler.ManifestError{Line: 5, Column: 10, Msg: "environment variable UNKNOWN is not set"},
+		},
+		{
+			name: "invalid task id",
+			manifest: `
+tasks:
+  - id: a/b
+    time: 2022-12-01T12:00:00Z
+    url: https://example.com/
+`,
+			wantErr: &scheduler.ManifestError{Line: 3, Column: 9, Msg: "task id contains invalid character /: task validation error"},
+		},
+		{
+			name: "invalid cron",
+			manifest: `
+recurrences:
+  - id: report
+    cron: "every day"
+    url: https://example.com/
+`,
+			wantErr: &scheduler.ManifestError{Line: 4, Column: 11, Msg: `invalid cron expression "every day": expected exactly 5 fields, found 2: [every day]`},
+		},
+		{
+			name: "duplicate task",
+			manifest: `
+tasks:
+  - id: release
+    time: 2022-12-01T12:00:00Z
+    url: https://example.com/
+  - id: release
+    time: 2022-12-01T12:00:00Z
+    url: https://example.com/
+`,
+			wantErr: &scheduler.ManifestError{Line: 6, Column: 5, Msg: "duplicate task release at 2022-12-01T12:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := scheduler.ParseManifest([]byte(tt.manifest), queuePath, "test_",
+				scheduler.WithManifestNow(func() time.Time { return now }),
+				scheduler.WithManifestLookupEnv(lookupEnv),
+			)
+			if tt.wantErr != nil {
+				var merr *scheduler.ManifestError
+				require.True(t, errors.As(err, &merr), "got: %v", err)
+				assert.Equal(t, tt.wantErr, merr)
+				assert.ErrorIs(t, err, scheduler.ErrInvalidManifest)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, got, len(tt.want))
+
+			for i, w := range tt.want {
+				task := got[i]
+				assert.Equal(t, queuePath, task.QueuePath)
+				assert.Equal(t, "test_", task.Prefix)
+				assert.Equal(t, w.id, task.ID)
+				assert.True(t, w.scheduledAt.Equal(task.ScheduledAt), "got: %v, want: %v", task.ScheduledAt, w.scheduledAt)
+				assert.Equal(t, w.method, task.Request.Method)
+				assert.Equal(t, w.url, task.Request.URL.String())
+				assert.Equal(t, w.header, task.Request.Header)
+				assert.Equal(t, w.auth, task.Authorization)
+				assert.Equal(t, w.version, task.Version)
+
+				var body []byte
+				if task.Request.Body != nil {
+					body, err = io.ReadAll(task.Request.Body)
+					require.NoError(t, err)
+				}
+				assert.Equal(t, w.body, string(body))
+			}
+		})
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "payload.json"), []byte(`{"payload":"file"}`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "manifest.yaml"), []byte(`
+tasks:
+  - id: file
+    time: 2022-12-01T12:00:00Z
+    url: https://example.com/
+    bodyFile: payload.json
+`), 0o600))
+
+	got, err := scheduler.LoadManifest(filepath.Join(dir, "manifest.yaml"), "projects/p/locations/l/queues/q", "test_")
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+
+	body, err := io.ReadAll(got[0].Request.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"payload":"file"}`, string(body))
+}
@@ -0,0 +1,157 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc/status"
+)
+
+// OutcomeUnchanged is the outcome of tasks which Sync left as they are.
+const OutcomeUnchanged = "unchanged"
+
+// MetricLabels identifies the scheduler which records metrics.
+type MetricLabels struct {
+	Queue  string
+	Prefix string
+}
+
+// Metrics receives measurements of scheduling operations.
+type Metrics interface {
+	// AddTasks counts n tasks with outcome, one of OutcomeCreated, OutcomeAlreadyExists,
+	// OutcomeDeleted, OutcomeUnchanged and OutcomeError.
+	AddTasks(ctx context.Context, labels MetricLabels, outcome string, n int)
+	// RecordRPC records the latency of a Cloud Tasks RPC such as "CreateTask".
+	RecordRPC(ctx context.Context, labels MetricLabels, method string, d time.Duration, err error)
+}
+
+// WithMetrics makes Sync, Create, Delete and Iterator record metrics to m.
+func WithMetrics(m Metrics) Option {
+	return func(s *Scheduler) {
+		s.metrics = m
+	}
+}
+
+func (s *Scheduler) metricLabels() MetricLabels {
+	return MetricLabels{Queue: s.queuePath, Prefix: s.prefix}
+}
+
+func (s *Scheduler) addTasks(ctx context.Context, outcome string, n int) {
+	if s.metrics == nil || n == 0 {
+		return
+	}
+	s.metrics.AddTasks(ctx, s.metricLabels(), outcome, n)
+}
+
+func (s *Scheduler) recordRPC(ctx context.Context, method string, start time.Time, err error) {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.RecordRPC(ctx, s.metricLabels(), method, time.Since(start), err)
+}
+
+// OTelMetrics is a Metrics which records to OpenTelemetry instruments
+// "scheduler.tasks" and "scheduler.rpc.duration".
+type OTelMetrics struct {
+	tasks    metric.Int64Counter
+	duration metric.Float64Histogram
+}
+
+func NewOTelMetrics(mp metric.MeterProvider) (*OTelMetrics, error) {
+	meter := mp.Meter(instrumentationName)
+	tasks, err := meter.Int64Counter("scheduler.tasks",
+		metric.WithDescription("Number of tasks by outcome of scheduling operations."),
+		metric.WithUnit("{task}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create counter: %w", err)
+	}
+	duration, err := meter.Float64Histogram("scheduler.rpc.duration",
+		metric.WithDescription("Latency of Cloud Tasks RPCs."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create histogram: %w", err)
+	}
+
+	return &OTelMetrics{
+		tasks:    tasks,
+		duration: duration,
+	}, nil
+}
+
+func (m *OTelMetrics) AddTasks(ctx context.Context, labels MetricLabels, outcome string, n int) {
+	m.tasks.Add(ctx, int64(n), metric.WithAttributes(
+		AttributeQueue.String(labels.Queue),
+		AttributePrefix.String(labels.Prefix),
+		AttributeOutcome.String(outcome),
+	))
+}
+
+func (m *OTelMetrics) RecordRPC(ctx context.Context, labels MetricLabels, method string, d time.Duration, err error) {
+	m.duration.Record(ctx, d.Seconds(), metric.WithAttributes(
+		AttributeQueue.String(labels.Queue),
+		AttributePrefix.String(labels.Prefix),
+		attribute.String("rpc.method", method),
+		attribute.String("rpc.grpc.status_code", status.Code(err).String()),
+	))
+}
+
+type memoryTaskKey struct {
+	labels  MetricLabels
+	outcome string
+}
+
+type memoryRPCKey struct {
+	labels MetricLabels
+	method string
+}
+
+// MemoryMetrics is a Metrics kept in memory for tests.
+type MemoryMetrics struct {
+	mu    sync.Mutex
+	tasks map[memoryTaskKey]int
+	rpcs  map[memoryRPCKey][]time.Duration
+}
+
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{
+		tasks: make(map[memoryTaskKey]int),
+		rpcs:  make(map[memoryRPCKey][]time.Duration),
+	}
+}
+
+func (m *MemoryMetrics) AddTasks(_ context.Context, labels MetricLabels, outcome string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tasks[memoryTaskKey{labels: labels, outcome: outcome}] += n
+}
+
+func (m *MemoryMetrics) RecordRPC(_ context.Context, labels MetricLabels, method string, d time.Duration, _ error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memoryRPCKey{labels: labels, method: method}
+	m.rpcs[key] = append(m.rpcs[key], d)
+}
+
+// Tasks returns the number of tasks counted with outcome.
+func (m *MemoryMetrics) Tasks(labels MetricLabels, outcome string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.tasks[memoryTaskKey{labels: labels, outcome: outcome}]
+}
+
+// RPCs returns the recorded latencies of method.
+func (m *MemoryMetrics) RPCs(labels MetricLabels, method string) []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]time.Duration(nil), m.rpcs[memoryRPCKey{labels: labels, method: method}]...)
+}
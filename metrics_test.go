@@ -0,0 +1,92 @@
+package scheduler_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/grpc/codes"
+
+	"github.com/go-oss/scheduler"
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+func TestScheduler_metrics(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	newTasks := func(url string) []*scheduler.Task {
+		var tasks []*scheduler.Task
+		for i := 0; i < 3; i++ {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+			tasks = append(tasks, &scheduler.Task{
+				QueuePath:   queuePath,
+				Prefix:      "test_",
+				ID:          "id",
+				ScheduledAt: time.Unix(int64(i), 0),
+				Request:     req,
+			})
+		}
+		return tasks
+	}
+
+	m := scheduler.NewMemoryMetrics()
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_", scheduler.WithMetrics(m))
+	require.NoError(t, s.Sync(ctx, newTasks("https://example.com/")))
+	require.NoError(t, s.Sync(ctx, append(newTasks("https://example.com/")[:1], newTasks("https://example.com/v2")[1:2]...)))
+	cli.Server.SetFault(schedulertest.FailMethod("CreateTask", codes.Internal, 1))
+	assert.Error(t, s.Create(ctx, newTasks("https://example.com/")[2]))
+
+	labels := scheduler.MetricLabels{Queue: queuePath, Prefix: "test_"}
+	assert.Equal(t, 4, m.Tasks(labels, scheduler.OutcomeCreated))
+	assert.Equal(t, 2, m.Tasks(labels, scheduler.OutcomeDeleted))
+	assert.Equal(t, 1, m.Tasks(labels, scheduler.OutcomeUnchanged))
+	assert.Equal(t, 1, m.Tasks(labels, scheduler.OutcomeError))
+	assert.Len(t, m.RPCs(labels, "CreateTask"), 5)
+	assert.Len(t, m.RPCs(labels, "DeleteTask"), 2)
+	assert.Len(t, m.RPCs(labels, "ListTasks"), 1, "the first list of an empty queue returns no task")
+}
+
+func TestOTelMetrics(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+	m, err := scheduler.NewOTelMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	require.NoError(t, err)
+
+	labels := scheduler.MetricLabels{Queue: "queue", Prefix: "test_"}
+	m.AddTasks(ctx, labels, scheduler.OutcomeCreated, 2)
+	m.AddTasks(ctx, labels, scheduler.OutcomeCreated, 1)
+	m.RecordRPC(ctx, labels, "CreateTask", time.Second, nil)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	got := make(map[string]metricdata.Aggregation)
+	for _, md := range rm.ScopeMetrics[0].Metrics {
+		got[md.Name] = md.Data
+	}
+
+	sum, ok := got["scheduler.tasks"].(metricdata.Sum[int64])
+	require.True(t, ok)
+	require.Len(t, sum.DataPoints, 1)
+	assert.Equal(t, int64(3), sum.DataPoints[0].Value)
+	outcome, _ := sum.DataPoints[0].Attributes.Value(scheduler.AttributeOutcome)
+	assert.Equal(t, scheduler.OutcomeCreated, outcome.AsString())
+
+	hist, ok := got["scheduler.rpc.duration"].(metricdata.Histogram[float64])
+	require.True(t, ok)
+	require.Len(t, hist.DataPoints, 1)
+	assert.Equal(t, 1.0, hist.DataPoints[0].Sum)
+}
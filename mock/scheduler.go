@@ -76,6 +76,26 @@ func (mr *MockCloudTasksClientMockRecorder) DeleteTask(ctx, req interface{}, opt
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTask", reflect.TypeOf((*MockCloudTasksClient)(nil).DeleteTask), varargs...)
 }
 
+// GetTask mocks base method.
+func (m *MockCloudTasksClient) GetTask(ctx context.Context, req *tasks.GetTaskRequest, opts ...gax.CallOption) (*tasks.Task, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, req}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetTask", varargs...)
+	ret0, _ := ret[0].(*tasks.Task)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTask indicates an expected call of GetTask.
+func (mr *MockCloudTasksClientMockRecorder) GetTask(ctx, req interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, req}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTask", reflect.TypeOf((*MockCloudTasksClient)(nil).GetTask), varargs...)
+}
+
 // ListTasks mocks base method.
 func (m *MockCloudTasksClient) ListTasks(ctx context.Context, req *tasks.ListTasksRequest, opts ...gax.CallOption) *cloudtasks.TaskIterator {
 	m.ctrl.T.Helper()
@@ -94,3 +114,23 @@ func (mr *MockCloudTasksClientMockRecorder) ListTasks(ctx, req interface{}, opts
 	varargs := append([]interface{}{ctx, req}, opts...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTasks", reflect.TypeOf((*MockCloudTasksClient)(nil).ListTasks), varargs...)
 }
+
+// RunTask mocks base method.
+func (m *MockCloudTasksClient) RunTask(ctx context.Context, req *tasks.RunTaskRequest, opts ...gax.CallOption) (*tasks.Task, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, req}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "RunTask", varargs...)
+	ret0, _ := ret[0].(*tasks.Task)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RunTask indicates an expected call of RunTask.
+func (mr *MockCloudTasksClientMockRecorder) RunTask(ctx, req interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, req}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunTask", reflect.TypeOf((*MockCloudTasksClient)(nil).RunTask), varargs...)
+}
@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type MuxOption func(*Mux)
+
+// WithNotFoundHandler sets the handler for tasks whose id matches no pattern.
+// By default they are answered with 404 Not Found, which makes Cloud Tasks retry them.
+func WithNotFoundHandler(h http.Handler) MuxOption {
+	return func(m *Mux) {
+		m.notFound = h
+	}
+}
+
+type muxEntry struct {
+	pattern string
+	h       http.Handler
+}
+
+// Mux dispatches task requests to handlers by task id.
+// It must be wrapped by Receiver to get the task metadata.
+//
+// Patterns are either exact ids, prefixes ending with a single "*" like "report-*",
+// or globs of path.Match. Exact ids take precedence over prefixes,
+// longer prefixes over shorter ones, and prefixes over globs, which are tried in registration order.
+type Mux struct {
+	mu       sync.RWMutex
+	exact    map[string]http.Handler
+	prefixes []muxEntry
+	globs    []muxEntry
+	notFound http.Handler
+}
+
+func NewMux(opts ...MuxOption) *Mux {
+	m := &Mux{
+		exact:    make(map[string]http.Handler),
+		notFound: http.NotFoundHandler(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Handle registers h for pattern. It panics if pattern is invalid or already registered.
+func (m *Mux) Handle(pattern string, h http.Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if pattern == "" {
+		panic("scheduler: empty pattern")
+	}
+	if h == nil {
+		panic("scheduler: nil handler")
+	}
+
+	switch {
+	case !strings.ContainsAny(pattern, `*?[\`):
+		if _, ok := m.exact[pattern]; ok {
+			panic("scheduler: multiple registrations for " + pattern)
+		}
+		m.exact[pattern] = h
+	case strings.HasSuffix(pattern, "*") && !strings.ContainsAny(pattern[:len(pattern)-1], `*?[\`):
+		for _, e := range m.prefixes {
+			if e.pattern == pattern {
+				panic("scheduler: multiple registrations for " + pattern)
+			}
+		}
+		m.prefixes = append(m.prefixes, muxEntry{pattern: pattern, h: h})
+		sort.SliceStable(m.prefixes, func(i, j int) bool {
+			return len(m.prefixes[i].pattern) > len(m.prefixes[j].pattern)
+		})
+	default:
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic(fmt.Sprintf("scheduler: invalid pattern %s: %v", pattern, err))
+		}
+		for _, e := range m.globs {
+			if e.pattern == pattern {
+				panic("scheduler: multiple registrations for " + pattern)
+			}
+		}
+		m.globs = append(m.globs, muxEntry{pattern: pattern, h: h})
+	}
+}
+
+func (m *Mux) HandleFunc(pattern string, f func(http.ResponseWriter, *http.Request)) {
+	m.Handle(pattern, http.HandlerFunc(f))
+}
+
+// Handler returns the handler for id and the matched pattern.
+// If no pattern matches, it returns the not found handler and an empty pattern.
+func (m *Mux) Handler(id string) (http.Handler, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if h, ok := m.exact[id]; ok {
+		return h, id
+	}
+	for _, e := range m.prefixes {
+		if strings.HasPrefix(id, e.pattern[:len(e.pattern)-1]) {
+			return e.h, e.pattern
+		}
+	}
+	for _, e := range m.globs {
+		if ok, _ := path.Match(e.pattern, id); ok {
+			return e.h, e.pattern
+		}
+	}
+
+	return m.notFound, ""
+}
+
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	info, ok := TaskInfoFromContext(r.Context())
+	if !ok {
+		http.Error(w, "task info is missing", http.StatusInternalServerError)
+		return
+	}
+
+	h, _ := m.Handler(info.ID)
+	h.ServeHTTP(w, r)
+}
+
+// JSONHandler returns a handler which decodes the request body as JSON into T and calls f.
+// Undecodable bodies are answered with 400 Bad Request, and errors of f with 500 Internal Server Error.
+func JSONHandler[T any](f func(ctx context.Context, v T) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v T
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if err := f(r.Context(), v); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
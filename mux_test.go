@@ -0,0 +1,93 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/go-oss/scheduler"
+)
+
+func TestMux(t *testing.T) {
+	t.Parallel()
+
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		})
+	}
+	m := scheduler.NewMux(scheduler.WithNotFoundHandler(named("not found")))
+	m.Handle("report", named("exact"))
+	m.Handle("report*", named("prefix"))
+	m.Handle("report-daily*", named("longer prefix"))
+	m.Handle("*-[0-9]", named("glob"))
+	h := scheduler.NewReceiver("test_").Handler(m)
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{id: "report", want: "exact"},
+		{id: "report-weekly", want: "prefix"},
+		{id: "report-daily-1", want: "longer prefix"},
+		{id: "sync-1", want: "glob"},
+		{id: "sync-a", want: "not found"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.id, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header.Set("X-CloudTasks-TaskName", "test_"+tt.id+"_3b9aca00v1")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Body.String())
+		})
+	}
+}
+
+func TestMux_Handle_panic(t *testing.T) {
+	t.Parallel()
+
+	m := scheduler.NewMux()
+	m.Handle("report", http.NotFoundHandler())
+	assert.Panics(t, func() { m.Handle("report", http.NotFoundHandler()) })
+	assert.Panics(t, func() { m.Handle("[", http.NotFoundHandler()) })
+}
+
+func TestJSONHandler(t *testing.T) {
+	t.Parallel()
+
+	type payload struct {
+		Name string `json:"name"`
+	}
+	var got payload
+	h := scheduler.JSONHandler(func(ctx context.Context, v payload) error {
+		if v.Name == "fail" {
+			return errors.New("failed")
+		}
+		got = v
+		return nil
+	})
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{body: `{"name":"test"}`, want: http.StatusOK},
+		{body: `{`, want: http.StatusBadRequest},
+		{body: `{"name":"fail"}`, want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+		assert.Equal(t, tt.want, rec.Code, tt.body)
+	}
+	assert.Equal(t, payload{Name: "test"}, got)
+}
@@ -0,0 +1,471 @@
+package scheduler
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GoogleJWKSURL is the JWKS endpoint of the keys which sign Google issued OIDC tokens.
+const GoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+var (
+	ErrInvalidOIDCToken = errors.New("invalid oidc token")
+	ErrKeyNotFound      = errors.New("key not found")
+)
+
+var defaultOIDCIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// KeySource provides public keys to verify token signatures.
+type KeySource interface {
+	Key(ctx context.Context, kid string) (*rsa.PublicKey, error)
+}
+
+// StaticKeySource is a KeySource of fixed keys indexed by key id.
+type StaticKeySource map[string]*rsa.PublicKey
+
+func (s StaticKeySource) Key(_ context.Context, kid string) (*rsa.PublicKey, error) {
+	key, ok := s[kid]
+	if !ok {
+		return nil, fmt.Errorf("kid %s: %w", kid, ErrKeyNotFound)
+	}
+
+	return key, nil
+}
+
+const (
+	// jwksMinRefreshInterval limits fetches of JWKSKeySource, which can be triggered by key ids of unverified tokens.
+	jwksMinRefreshInterval = time.Minute
+	// maxUnknownKids bounds the negative cache of JWKSKeySource.
+	maxUnknownKids = 1024
+)
+
+// JWKSKeySource is a KeySource which fetches keys from a JWKS endpoint.
+// Keys are cached as long as the Cache-Control max-age of the response,
+// and fetched again when an unknown key id is requested.
+// Fetches are made at most once a minute, unknown key ids are not fetched again until the next refresh,
+// and the cached keys are kept if a fetch fails.
+type JWKSKeySource struct {
+	url    string
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	expiresAt time.Time
+	fetchedAt time.Time
+	unknown   map[string]bool
+	fetching  chan struct{}
+}
+
+func NewJWKSKeySource(url string, client *http.Client) *JWKSKeySource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &JWKSKeySource{
+		url:    url,
+		client: client,
+		now:    time.Now,
+	}
+}
+
+func (s *JWKSKeySource) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	for {
+		s.mu.Lock()
+		now := s.now()
+		key, ok := s.keys[kid]
+		stale := !now.Before(s.expiresAt)
+		refresh := stale || (!ok && !s.unknown[kid])
+
+		// wait for the fetch of another call
+		if fetching := s.fetching; refresh && fetching != nil {
+			s.mu.Unlock()
+			select {
+			case <-fetching:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		if !refresh || now.Sub(s.fetchedAt) < jwksMinRefreshInterval {
+			s.mu.Unlock()
+			if !ok {
+				return nil, fmt.Errorf("kid %s: %w", kid, ErrKeyNotFound)
+			}
+			return key, nil
+		}
+
+		fetching, fetchedAt := make(chan struct{}), s.fetchedAt
+		s.fetching, s.fetchedAt = fetching, now
+		s.mu.Unlock()
+
+		keys, ttl, err := s.fetch(ctx)
+
+		s.mu.Lock()
+		switch {
+		case err == nil:
+			s.keys, s.expiresAt, s.unknown = keys, now.Add(ttl), nil
+		case ctx.Err() != nil:
+			// the fetch was abandoned by the caller, so the next call can fetch at once
+			s.fetchedAt = fetchedAt
+		}
+		key, ok = s.keys[kid]
+		if !ok && err == nil {
+			if s.unknown == nil {
+				s.unknown = make(map[string]bool)
+			}
+			if len(s.unknown) < maxUnknownKids {
+				s.unknown[kid] = true
+			}
+		}
+		s.fetching = nil
+		close(fetching)
+		s.mu.Unlock()
+
+		switch {
+		case ok:
+			return key, nil
+		case err != nil:
+			return nil, err
+		default:
+			return nil, fmt.Errorf("kid %s: %w", kid, ErrKeyNotFound)
+		}
+	}
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// fetch fetches the keys and how long they can be cached.
+func (s *JWKSKeySource) fetch(ctx context.Context) (map[string]*rsa.PublicKey, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create jwks request: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to fetch jwks: unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		key, err := k.rsaPublicKey()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decode jwk %s: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, maxAge(resp.Header.Get("Cache-Control")), nil
+}
+
+func (k *jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func maxAge(cacheControl string) time.Duration {
+	for _, d := range strings.Split(cacheControl, ",") {
+		d = strings.TrimSpace(d)
+		if !strings.HasPrefix(d, "max-age=") {
+			continue
+		}
+		sec, err := strconv.Atoi(strings.TrimPrefix(d, "max-age="))
+		if err != nil {
+			return 0
+		}
+		return time.Duration(sec) * time.Second
+	}
+
+	return 0
+}
+
+// OIDCClaims is the claims of a verified OIDC token.
+type OIDCClaims struct {
+	Issuer        string
+	Subject       string
+	Audience      []string
+	Email         string
+	EmailVerified bool
+	IssuedAt      time.Time
+	ExpiresAt     time.Time
+}
+
+type oidcClaimsKey struct{}
+
+// OIDCClaimsFromContext returns the OIDCClaims stored by OIDCVerifier.
+func OIDCClaimsFromContext(ctx context.Context) (*OIDCClaims, bool) {
+	claims, ok := ctx.Value(oidcClaimsKey{}).(*OIDCClaims)
+	return claims, ok
+}
+
+type OIDCVerifierOption func(*OIDCVerifier)
+
+// WithKeySource sets the source of signing keys. The default fetches GoogleJWKSURL.
+func WithKeySource(keys KeySource) OIDCVerifierOption {
+	return func(v *OIDCVerifier) {
+		v.keys = keys
+	}
+}
+
+// WithOIDCIssuers sets the accepted issuers. The default accepts Google.
+func WithOIDCIssuers(issuers ...string) OIDCVerifierOption {
+	return func(v *OIDCVerifier) {
+		v.issuers = issuers
+	}
+}
+
+// WithOIDCAudience sets the audiences which Handler accepts when OIDCToken.Audience is empty,
+// usually the target urls of the tasks, which Cloud Tasks uses as the audience by default.
+func WithOIDCAudience(audiences ...string) OIDCVerifierOption {
+	return func(v *OIDCVerifier) {
+		v.audiences = audiences
+	}
+}
+
+// WithOIDCLeeway sets the allowed clock skew when checking the expiry.
+func WithOIDCLeeway(d time.Duration) OIDCVerifierOption {
+	return func(v *OIDCVerifier) {
+		v.leeway = d
+	}
+}
+
+func WithOIDCClock(now func() time.Time) OIDCVerifierOption {
+	return func(v *OIDCVerifier) {
+		v.now = now
+	}
+}
+
+// WithOIDCErrorHandler sets the handler for requests which are rejected.
+// By default they are answered with 401 Unauthorized.
+func WithOIDCErrorHandler(f func(w http.ResponseWriter, r *http.Request, err error)) OIDCVerifierOption {
+	return func(v *OIDCVerifier) {
+		v.errorHandler = f
+	}
+}
+
+// OIDCVerifier verifies the OIDC tokens which Cloud Tasks attaches to requests of tasks with OIDCToken.
+type OIDCVerifier struct {
+	token        *OIDCToken
+	keys         KeySource
+	issuers      []string
+	audiences    []string
+	leeway       time.Duration
+	now          func() time.Time
+	errorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+func NewOIDCVerifier(token *OIDCToken, opts ...OIDCVerifierOption) *OIDCVerifier {
+	v := &OIDCVerifier{
+		token:   token,
+		issuers: defaultOIDCIssuers,
+		now:     time.Now,
+		errorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		},
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	if v.keys == nil {
+		v.keys = NewJWKSKeySource(GoogleJWKSURL, nil)
+	}
+
+	return v
+}
+
+// Verify verifies the signature and the claims of rawToken.
+// If OIDCToken.Audience is empty, audience is checked instead, which Cloud Tasks sets to the target url,
+// or the audiences of WithOIDCAudience if audience is empty as well.
+// Tokens are rejected if no audience is configured.
+func (v *OIDCVerifier) Verify(ctx context.Context, rawToken, audience string) (*OIDCClaims, error) {
+	audiences := v.audiences
+	switch {
+	case v.token.Audience != "":
+		audiences = []string{v.token.Audience}
+	case audience != "":
+		audiences = []string{audience}
+	}
+	if len(audiences) == 0 {
+		return nil, fmt.Errorf("audience is not configured: %w", ErrInvalidOIDCToken)
+	}
+
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token: %w", ErrInvalidOIDCToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("failed to decode header: %v: %w", err, ErrInvalidOIDCToken)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported algorithm %s: %w", header.Alg, ErrInvalidOIDCToken)
+	}
+
+	key, err := v.keys.Key(ctx, header.Kid)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, fmt.Errorf("%v: %w", err, ErrInvalidOIDCToken)
+		}
+		return nil, fmt.Errorf("failed to get key: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %v: %w", err, ErrInvalidOIDCToken)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", ErrInvalidOIDCToken)
+	}
+
+	var payload struct {
+		Iss           string          `json:"iss"`
+		Sub           string          `json:"sub"`
+		Aud           json.RawMessage `json:"aud"`
+		Email         string          `json:"email"`
+		EmailVerified bool            `json:"email_verified"`
+		Iat           int64           `json:"iat"`
+		Exp           int64           `json:"exp"`
+	}
+	if err := decodeSegment(parts[1], &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode claims: %v: %w", err, ErrInvalidOIDCToken)
+	}
+	claims := &OIDCClaims{
+		Issuer:        payload.Iss,
+		Subject:       payload.Sub,
+		Email:         payload.Email,
+		EmailVerified: payload.EmailVerified,
+		IssuedAt:      time.Unix(payload.Iat, 0),
+		ExpiresAt:     time.Unix(payload.Exp, 0),
+	}
+	if claims.Audience, err = decodeAudience(payload.Aud); err != nil {
+		return nil, fmt.Errorf("failed to decode audience: %v: %w", err, ErrInvalidOIDCToken)
+	}
+
+	if !contains(v.issuers, claims.Issuer) {
+		return nil, fmt.Errorf("unexpected issuer %s: %w", claims.Issuer, ErrInvalidOIDCToken)
+	}
+	if !containsAny(claims.Audience, audiences) {
+		return nil, fmt.Errorf("unexpected audience %v: %w", claims.Audience, ErrInvalidOIDCToken)
+	}
+	if claims.Email != v.token.ServiceAccountEmail || !claims.EmailVerified {
+		return nil, fmt.Errorf("unexpected email %s: %w", claims.Email, ErrInvalidOIDCToken)
+	}
+	if payload.Exp == 0 || !v.now().Before(claims.ExpiresAt.Add(v.leeway)) {
+		return nil, fmt.Errorf("token is expired at %s: %w", claims.ExpiresAt, ErrInvalidOIDCToken)
+	}
+
+	return claims, nil
+}
+
+// Handler rejects requests without a valid bearer token and stores OIDCClaims in the request context.
+// The audience is OIDCToken.Audience or the audiences of WithOIDCAudience; the request url is not trusted,
+// since its host and scheme come from the client, so every request is rejected if neither is set.
+func (v *OIDCVerifier) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+			v.errorHandler(w, r, fmt.Errorf("bearer token is missing: %w", ErrInvalidOIDCToken))
+			return
+		}
+
+		claims, err := v.Verify(r.Context(), auth[len("Bearer "):], "")
+		if err != nil {
+			v.errorHandler(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), oidcClaimsKey{}, claims)))
+	})
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func decodeAudience(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var aud string
+	if err := json.Unmarshal(raw, &aud); err == nil {
+		return []string{aud}, nil
+	}
+	var auds []string
+	if err := json.Unmarshal(raw, &auds); err != nil {
+		return nil, err
+	}
+
+	return auds, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsAny(ss, vs []string) bool {
+	for _, v := range vs {
+		if contains(ss, v) {
+			return true
+		}
+	}
+
+	return false
+}
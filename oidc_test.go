@@ -0,0 +1,315 @@
+package scheduler_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oss/scheduler"
+)
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCVerifier_Verify(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	now := time.Unix(1669852800, 0)
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":            "https://accounts.google.com",
+			"sub":            "1234",
+			"aud":            "https://example.com",
+			"email":          "invoker@example.com",
+			"email_verified": true,
+			"iat":            now.Add(-time.Minute).Unix(),
+			"exp":            now.Add(time.Hour).Unix(),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		kid     string
+		modify  func(claims map[string]interface{})
+		wantErr error
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name: "audience list",
+			modify: func(claims map[string]interface{}) {
+				claims["aud"] = []string{"other", "https://example.com"}
+			},
+		},
+		{
+			name:    "unknown kid",
+			kid:     "unknown",
+			wantErr: scheduler.ErrInvalidOIDCToken,
+		},
+		{
+			name:    "invalid signature",
+			key:     otherKey,
+			wantErr: scheduler.ErrInvalidOIDCToken,
+		},
+		{
+			name: "unexpected issuer",
+			modify: func(claims map[string]interface{}) {
+				claims["iss"] = "https://example.com"
+			},
+			wantErr: scheduler.ErrInvalidOIDCToken,
+		},
+		{
+			name: "unexpected audience",
+			modify: func(claims map[string]interface{}) {
+				claims["aud"] = "https://example.org"
+			},
+			wantErr: scheduler.ErrInvalidOIDCToken,
+		},
+		{
+			name: "unexpected email",
+			modify: func(claims map[string]interface{}) {
+				claims["email"] = "other@example.com"
+			},
+			wantErr: scheduler.ErrInvalidOIDCToken,
+		},
+		{
+			name: "unverified email",
+			modify: func(claims map[string]interface{}) {
+				claims["email_verified"] = false
+			},
+			wantErr: scheduler.ErrInvalidOIDCToken,
+		},
+		{
+			name: "expired",
+			modify: func(claims map[string]interface{}) {
+				claims["exp"] = now.Add(-time.Second).Unix()
+			},
+			wantErr: scheduler.ErrInvalidOIDCToken,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			signKey, kid := key, "kid1"
+			if tt.key != nil {
+				signKey = tt.key
+			}
+			if tt.kid != "" {
+				kid = tt.kid
+			}
+			claims := validClaims()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+
+			v := scheduler.NewOIDCVerifier(
+				&scheduler.OIDCToken{ServiceAccountEmail: "invoker@example.com", Audience: "https://example.com"},
+				scheduler.WithKeySource(scheduler.StaticKeySource{"kid1": &key.PublicKey}),
+				scheduler.WithOIDCClock(func() time.Time { return now }),
+			)
+			got, err := v.Verify(context.Background(), signToken(t, signKey, kid, claims), "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got: %v, want: %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				assert.Equal(t, "invoker@example.com", got.Email)
+				assert.Equal(t, "1234", got.Subject)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifier_Handler(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var fetched int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "kid1",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwks.Close()
+
+	keys := scheduler.NewJWKSKeySource(jwks.URL, jwks.Client())
+	v := scheduler.NewOIDCVerifier(
+		&scheduler.OIDCToken{ServiceAccountEmail: "invoker@example.com"},
+		scheduler.WithKeySource(keys),
+		scheduler.WithOIDCAudience("https://example.com/tasks?id=1"),
+	)
+	h := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := scheduler.OIDCClaimsFromContext(r.Context())
+		require.True(t, ok)
+		assert.Equal(t, "invoker@example.com", claims.Email)
+	}))
+
+	token := signToken(t, key, "kid1", map[string]interface{}{
+		"iss":            "https://accounts.google.com",
+		"aud":            "https://example.com/tasks?id=1",
+		"email":          "invoker@example.com",
+		"email_verified": true,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "https://example.com/tasks?id=1", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetched), "keys are cached")
+
+	other := signToken(t, key, "kid1", map[string]interface{}{
+		"iss":            "https://accounts.google.com",
+		"aud":            "https://attacker.example.com/tasks?id=1",
+		"email":          "invoker@example.com",
+		"email_verified": true,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	})
+	req := httptest.NewRequest(http.MethodPost, "https://attacker.example.com/tasks?id=1", nil)
+	req.Header.Set("Authorization", "Bearer "+other)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "audience is not taken from the request url")
+
+	unconfigured := scheduler.NewOIDCVerifier(
+		&scheduler.OIDCToken{ServiceAccountEmail: "invoker@example.com"},
+		scheduler.WithKeySource(keys),
+	).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request without a configured audience is accepted")
+	}))
+	req = httptest.NewRequest(http.MethodPost, "https://example.com/tasks?id=1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	unconfigured.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "audience must be configured")
+
+	req = httptest.NewRequest(http.MethodPost, "https://example.com/tasks?id=1", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestJWKSKeySource_Key(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var (
+		fetched int32
+		failing atomic.Bool
+		release = make(chan struct{})
+	)
+	close(release)
+	var block atomic.Pointer[chan struct{}]
+	block.Store(&release)
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		<-*block.Load()
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "kid1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwks.Close()
+
+	now := time.Unix(0, 0)
+	src := scheduler.NewJWKSKeySource(jwks.URL, jwks.Client())
+	src.SetNow(func() time.Time { return now })
+
+	// concurrent calls share a fetch
+	wait := make(chan struct{})
+	block.Store(&wait)
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, err := src.Key(ctx, "kid1")
+			errs <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(wait)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, <-errs)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetched))
+
+	_, err = src.Key(ctx, "unknown")
+	assert.ErrorIs(t, err, scheduler.ErrKeyNotFound)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetched), "refreshes are rate limited")
+
+	now = now.Add(2 * time.Minute)
+	_, err = src.Key(ctx, "unknown")
+	assert.ErrorIs(t, err, scheduler.ErrKeyNotFound)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetched), "unknown kids trigger a refresh")
+
+	now = now.Add(2 * time.Minute)
+	_, err = src.Key(ctx, "unknown")
+	assert.ErrorIs(t, err, scheduler.ErrKeyNotFound)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetched), "unknown kids are cached")
+
+	now = now.Add(2 * time.Hour)
+	failing.Store(true)
+	got, err := src.Key(ctx, "kid1")
+	require.NoError(t, err, "keys are kept if the refresh fails")
+	assert.Equal(t, &key.PublicKey, got)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetched))
+}
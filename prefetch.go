@@ -0,0 +1,125 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+)
+
+type prefetchedPage struct {
+	tasks []*Task
+	start string
+	next  string
+	err   error
+}
+
+type prefetcher struct {
+	pages         chan prefetchedPage
+	cancel        context.CancelFunc
+	buf           []*Task
+	consumedStart string
+	consumedNext  string
+	drained       bool
+	err           error
+}
+
+// WithPrefetch makes the iterator fetch up to pages pages in the background while the current one is consumed.
+// The fetch stops at the first error, which is returned after the tasks fetched before it.
+// Close must be called to stop the fetch if the iteration is abandoned before Done, otherwise its goroutine leaks.
+// Filters and the malformed task policy are called on the goroutine of the fetch, so they must be safe to call from it.
+func (i *Iterator) WithPrefetch(pages int) *Iterator {
+	if pages > 0 {
+		i.prefetch = pages
+	}
+	return i
+}
+
+// Close stops the background fetch of WithPrefetch and waits for it to finish.
+// Next returns Done after Close.
+func (i *Iterator) Close() {
+	if i.cancel != nil {
+		i.cancel()
+		for range i.pages {
+		}
+	}
+	i.stopped, i.peeked, i.peekedErr = true, nil, nil
+	i.buf = nil
+	i.err = Done
+}
+
+func (i *Iterator) startPrefetch(ctx context.Context) {
+	ctx, i.cancel = context.WithCancel(ctx)
+	i.pages = make(chan prefetchedPage, i.prefetch)
+	go func() {
+		defer close(i.pages)
+		for {
+			tasks, next, err := i.fetchPage(ctx)
+			if errors.Is(err, Done) {
+				return
+			}
+
+			select {
+			case i.pages <- prefetchedPage{tasks: tasks, start: i.pageStart, next: next, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (i *Iterator) receivePage(ctx context.Context) (prefetchedPage, error) {
+	if i.err != nil {
+		return prefetchedPage{}, i.err
+	}
+	if i.pages == nil {
+		i.startPrefetch(ctx)
+	}
+
+	select {
+	case p, ok := <-i.pages:
+		if !ok {
+			i.drained = true
+			return prefetchedPage{}, Done
+		}
+		if p.err != nil {
+			i.err = p.err
+			return prefetchedPage{}, p.err
+		}
+		i.consumedStart, i.consumedNext = p.start, p.next
+		return p, nil
+	case <-ctx.Done():
+		return prefetchedPage{}, ctx.Err()
+	}
+}
+
+func (i *Iterator) nextPrefetched(ctx context.Context) (*Task, error) {
+	for len(i.buf) == 0 {
+		p, err := i.receivePage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		i.buf = p.tasks
+	}
+
+	t := i.buf[0]
+	i.buf = i.buf[1:]
+	return t, nil
+}
+
+func (i *Iterator) nextPrefetchedPage(ctx context.Context) ([]*Task, string, error) {
+	// the rest of the page partially consumed by Next
+	if len(i.buf) > 0 {
+		tasks := i.buf
+		i.buf = nil
+		return tasks, i.consumedNext, nil
+	}
+
+	p, err := i.receivePage(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return p.tasks, p.next, nil
+}
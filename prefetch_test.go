@@ -0,0 +1,183 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/go-oss/scheduler"
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+func newPrefetchTestClient(t *testing.T, n int) (*schedulertest.FakeClient, *atomic.Int32) {
+	t.Helper()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { cli.Close() })
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	for i := 0; i < n; i++ {
+		cli.Server.AddTask(&taskspb.Task{
+			Name:         queuePath + "/tasks/test_" + strconv.Itoa(i) + "_3b9aca00v1",
+			ScheduleTime: &timestamppb.Timestamp{Seconds: 1},
+			MessageType:  &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: "https://example.com/", HttpMethod: taskspb.HttpMethod_POST}},
+		})
+	}
+
+	var calls atomic.Int32
+	cli.Server.SetFault(func(_ context.Context, method string, _ proto.Message) error {
+		if method == "ListTasks" {
+			calls.Add(1)
+		}
+		return nil
+	})
+
+	return cli, &calls
+}
+
+func TestIterator_WithPrefetch(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, _ := newPrefetchTestClient(t, 5)
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_", scheduler.WithPrefetch(2))
+
+	want, err := scheduler.Collect(s.List().WithPageSize(2).All(ctx), 0)
+	require.NoError(t, err)
+	require.Len(t, want, 5)
+
+	it := s.List().WithPageSize(2)
+	for i := 0; i < 3; i++ {
+		got, err := it.Next(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want[i].TaskName(), got.TaskName())
+	}
+	token, ok := it.PageToken()
+	require.True(t, ok)
+	rest, err := scheduler.Collect(s.ListFrom(token).WithPageSize(2).All(ctx), 0)
+	require.NoError(t, err)
+	assert.Len(t, rest, 3, "resumes from the page of the last task")
+
+	tasks, next, err := it.NextPage(ctx)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1, "the rest of the current page")
+	assert.Equal(t, want[3].TaskName(), tasks[0].TaskName())
+	assert.NotEmpty(t, next)
+	tasks, next, err = it.NextPage(ctx)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, want[4].TaskName(), tasks[0].TaskName())
+	assert.Empty(t, next)
+	_, _, err = it.NextPage(ctx)
+	assert.ErrorIs(t, err, scheduler.Done)
+	_, ok = it.PageToken()
+	assert.False(t, ok, "nothing to resume")
+}
+
+func TestIterator_WithPrefetch_bounded(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, calls := newPrefetchTestClient(t, 10)
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+
+	it := s.List().WithPageSize(1).WithPrefetch(2)
+	_, err := it.Next(ctx)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return calls.Load() >= 3 }, time.Second, time.Millisecond, "pages are fetched in the background")
+	time.Sleep(50 * time.Millisecond)
+	assert.LessOrEqual(t, calls.Load(), int32(4), "the consumed page, 2 buffered pages and 1 blocked page at most")
+
+	it.Close()
+	n := calls.Load()
+	_, err = it.Next(ctx)
+	assert.ErrorIs(t, err, scheduler.Done)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, n, calls.Load(), "fetch is stopped")
+}
+
+func TestIterator_WithPrefetch_error(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, _ := newPrefetchTestClient(t, 3)
+	var calls atomic.Int32
+	cli.Server.SetFault(func(_ context.Context, method string, _ proto.Message) error {
+		if method == "ListTasks" && calls.Add(1) == 2 {
+			return status.Error(codes.PermissionDenied, "denied")
+		}
+		return nil
+	})
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+
+	it := s.List().WithPageSize(2).WithPrefetch(1)
+	for i := 0; i < 2; i++ {
+		_, err := it.Next(ctx)
+		require.NoError(t, err, "tasks fetched before the error are returned")
+	}
+	_, err := it.Next(ctx)
+	assert.Equal(t, codes.PermissionDenied, status.Code(errors.Unwrap(err)))
+	_, err = it.Next(ctx)
+	assert.Equal(t, codes.PermissionDenied, status.Code(errors.Unwrap(err)), "the error is kept")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.List().WithPrefetch(1).Next(canceled)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func prefetchGoroutines() int {
+	buf := make([]byte, 1<<20)
+	return strings.Count(string(buf[:runtime.Stack(buf, true)]), "created by github.com/go-oss/scheduler.(*Iterator).startPrefetch")
+}
+
+// TestScheduler_WithPrefetch_leak is not parallel to count the prefetch goroutines of its own.
+func TestScheduler_WithPrefetch_leak(t *testing.T) {
+	ctx := context.Background()
+	cli, _ := newPrefetchTestClient(t, 10)
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_", scheduler.WithPrefetch(1))
+
+	tasks, next, err := s.ListPage(ctx, "", 1)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	require.NotEmpty(t, next)
+	_, err = s.FindByID(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, prefetchGoroutines(), "iterators used by the scheduler are closed")
+
+	it := s.List().WithPageSize(1)
+	_, err = it.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, prefetchGoroutines(), "abandoned iterators keep fetching until closed")
+	it.Close()
+	assert.Equal(t, 0, prefetchGoroutines())
+}
+
+func TestScheduler_ListPage_withPrefetch(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, calls := newPrefetchTestClient(t, 5)
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_", scheduler.WithPrefetch(2))
+
+	tasks, next, err := s.ListPage(ctx, "", 2)
+	require.NoError(t, err)
+	assert.Len(t, tasks, 2)
+	assert.NotEmpty(t, next)
+	assert.Equal(t, int32(1), calls.Load(), "only the requested page is fetched")
+}
@@ -0,0 +1,266 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/googleapis/gax-go/v2"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// TaskFilter selects tasks. A nil TaskFilter selects every task.
+type TaskFilter func(*Task) bool
+
+// PurgeProgress is reported after every deletion of Purge.
+type PurgeProgress struct {
+	Total   int
+	Deleted int
+	Failed  int
+	Task    *Task
+	Err     error
+}
+
+type purgeConfig struct {
+	concurrency int
+	progress    func(PurgeProgress)
+	callOpts    []gax.CallOption
+}
+
+type PurgeOption func(*purgeConfig)
+
+// WithPurgeConcurrency sets the number of concurrent deletions. The default is 10.
+func WithPurgeConcurrency(n int) PurgeOption {
+	return func(c *purgeConfig) {
+		if n > 0 {
+			c.concurrency = n
+		}
+	}
+}
+
+// WithPurgeProgress sets the function called after every deletion.
+// Calls are serialized.
+func WithPurgeProgress(f func(PurgeProgress)) PurgeOption {
+	return func(c *purgeConfig) {
+		c.progress = f
+	}
+}
+
+func WithPurgeCallOptions(opts ...gax.CallOption) PurgeOption {
+	return func(c *purgeConfig) {
+		c.callOpts = opts
+	}
+}
+
+func newPurgeConfig(opts []PurgeOption) *purgeConfig {
+	cfg := &purgeConfig{concurrency: 10}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return cfg
+}
+
+// Purge deletes the tasks of the prefix selected by filter, and returns the number of deleted tasks.
+// It continues on failures, and returns them joined.
+func (s *Scheduler) Purge(ctx context.Context, filter TaskFilter, opts ...PurgeOption) (int, error) {
+	cfg := newPurgeConfig(opts)
+	tasks, err := s.collect(ctx, s.List(cfg.callOpts...), filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.deleteTasks(ctx, tasks, cfg)
+}
+
+func (s *Scheduler) collect(ctx context.Context, iter *Iterator, filter TaskFilter) ([]*Task, error) {
+	defer iter.Close()
+	var tasks []*Task
+	for {
+		t, err := iter.Next(ctx)
+		if err != nil {
+			if errors.Is(err, Done) {
+				return tasks, nil
+			}
+			return nil, fmt.Errorf("failed to iterate remoteTasks: %w", err)
+		}
+		if filter == nil || filter(t) {
+			tasks = append(tasks, t)
+		}
+	}
+}
+
+func (s *Scheduler) deleteTasks(ctx context.Context, tasks []*Task, cfg *purgeConfig) (int, error) {
+	var (
+		mu       sync.Mutex
+		progress = PurgeProgress{Total: len(tasks)}
+		errs     []error
+		wg       sync.WaitGroup
+	)
+	ch := make(chan *Task)
+	for i := 0; i < cfg.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range ch {
+				err := s.Delete(ctx, t.TaskName(), cfg.callOpts...)
+				if status.Code(errors.Unwrap(err)) == codes.NotFound {
+					err = nil
+				}
+
+				mu.Lock()
+				if err != nil {
+					progress.Failed++
+					errs = append(errs, err)
+				} else {
+					progress.Deleted++
+				}
+				progress.Task, progress.Err = t, err
+				if cfg.progress != nil {
+					cfg.progress(progress)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+send:
+	for _, t := range tasks {
+		select {
+		case ch <- t:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(ch)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return progress.Deleted, errors.Join(errs...)
+}
+
+// Snapshot is the tasks deleted by Suspend.
+// It can be persisted as JSON to Resume in another process.
+type Snapshot struct {
+	QueuePath   string
+	Prefix      string
+	SuspendedAt time.Time
+	Tasks       []*Task
+}
+
+type snapshotJSON struct {
+	QueuePath   string            `json:"queuePath"`
+	Prefix      string            `json:"prefix"`
+	SuspendedAt time.Time         `json:"suspendedAt"`
+	Tasks       []json.RawMessage `json:"tasks"`
+}
+
+func (s *Snapshot) MarshalJSON() ([]byte, error) {
+	v := snapshotJSON{
+		QueuePath:   s.QueuePath,
+		Prefix:      s.Prefix,
+		SuspendedAt: s.SuspendedAt,
+		Tasks:       make([]json.RawMessage, 0, len(s.Tasks)),
+	}
+	for _, t := range s.Tasks {
+		pb, err := TaskToPbTask(t)
+		if err != nil {
+			return nil, err
+		}
+		b, err := protojson.Marshal(pb)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode task: %w", err)
+		}
+		v.Tasks = append(v.Tasks, b)
+	}
+
+	return json.Marshal(v)
+}
+
+func (s *Snapshot) UnmarshalJSON(b []byte) error {
+	var v snapshotJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	tasks := make([]*Task, 0, len(v.Tasks))
+	for _, raw := range v.Tasks {
+		pb := &taskspb.Task{}
+		if err := protojson.Unmarshal(raw, pb); err != nil {
+			return fmt.Errorf("failed to decode task: %w", err)
+		}
+		t, err := PbTaskToTask(context.Background(), v.QueuePath, v.Prefix, pb)
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, t)
+	}
+	*s = Snapshot{
+		QueuePath:   v.QueuePath,
+		Prefix:      v.Prefix,
+		SuspendedAt: v.SuspendedAt,
+		Tasks:       tasks,
+	}
+
+	return nil
+}
+
+// Suspend snapshots the tasks of the prefix selected by filter with their bodies and deletes them.
+// If some deletions fail, the snapshot is returned with the error so that it can still be resumed.
+func (s *Scheduler) Suspend(ctx context.Context, filter TaskFilter, opts ...PurgeOption) (*Snapshot, error) {
+	cfg := newPurgeConfig(opts)
+	tasks, err := s.collect(ctx, s.List(cfg.callOpts...).WithFullView(), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{
+		QueuePath:   s.queuePath,
+		Prefix:      s.prefix,
+		SuspendedAt: time.Now(),
+		Tasks:       tasks,
+	}
+	if _, err := s.deleteTasks(ctx, tasks, cfg); err != nil {
+		return snap, err
+	}
+
+	return snap, nil
+}
+
+// Resume recreates the tasks of snap which are scheduled in the future.
+// Names of deleted tasks can't be reused for a while, so the versions are bumped.
+// Resume can be retried since tasks which already exist are skipped,
+// and so are the tasks which Suspend failed to delete.
+func (s *Scheduler) Resume(ctx context.Context, snap *Snapshot, opts ...gax.CallOption) error {
+	remoteTasks, err := s.collect(ctx, s.List(opts...), nil)
+	if err != nil {
+		return err
+	}
+	remaining := make(map[string]bool, len(remoteTasks))
+	for _, t := range remoteTasks {
+		remaining[t.TaskName()] = true
+	}
+
+	now := time.Now()
+	for _, t := range snap.Tasks {
+		if !t.ScheduledAt.After(now) || remaining[t.TaskName()] {
+			continue
+		}
+
+		resumed := *t
+		resumed.Version = t.Version + 1
+		if err := s.Create(ctx, &resumed, opts...); err != nil && !errors.Is(err, ErrTaskAlreadyExists) {
+			return err
+		}
+	}
+
+	return nil
+}
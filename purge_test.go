@@ -0,0 +1,141 @@
+package scheduler_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	"github.com/go-oss/scheduler"
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+func newPurgeTestTasks(ctx context.Context, queuePath, prefix string, ids []string, at time.Time) []*scheduler.Task {
+	var tasks []*scheduler.Task
+	for _, id := range ids {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "https://example.com/", strings.NewReader(`{"id":"`+id+`"}`))
+		tasks = append(tasks, &scheduler.Task{
+			QueuePath:   queuePath,
+			Prefix:      prefix,
+			ID:          id,
+			ScheduledAt: at,
+			Request:     req,
+		})
+	}
+	return tasks
+}
+
+func taskNames(cli *schedulertest.FakeClient, queuePath string) []string {
+	var names []string
+	for _, t := range cli.Server.Tasks(queuePath) {
+		names = append(names, path.Base(t.Name))
+	}
+	return names
+}
+
+func TestScheduler_Purge(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+	other := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "other_")
+	require.NoError(t, s.Sync(ctx, newPurgeTestTasks(ctx, queuePath, "test_", []string{"a", "b1", "b2", "b3"}, time.Unix(1, 0))))
+	require.NoError(t, other.Sync(ctx, newPurgeTestTasks(ctx, queuePath, "other_", []string{"b1"}, time.Unix(1, 0))))
+
+	var progress []scheduler.PurgeProgress
+	n, err := s.Purge(ctx, func(t *scheduler.Task) bool {
+		return strings.HasPrefix(t.ID, "b")
+	}, scheduler.WithPurgeConcurrency(2), scheduler.WithPurgeProgress(func(p scheduler.PurgeProgress) {
+		progress = append(progress, p)
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	require.Len(t, progress, 3)
+	assert.Equal(t, 3, progress[2].Total)
+	assert.Equal(t, 3, progress[2].Deleted)
+	assert.Equal(t, []string{"other_b1_3b9aca00v1", "test_a_3b9aca00v1"}, taskNames(cli, queuePath), "other prefixes are kept")
+
+	n, err = s.Purge(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"other_b1_3b9aca00v1"}, taskNames(cli, queuePath))
+}
+
+func TestScheduler_SuspendResume(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+	future := time.Unix(4102444800, 0) // 2100-01-01
+	tasks := append(
+		newPurgeTestTasks(ctx, queuePath, "test_", []string{"past"}, time.Unix(1, 0)),
+		newPurgeTestTasks(ctx, queuePath, "test_", []string{"future"}, future)...,
+	)
+	require.NoError(t, s.Sync(ctx, tasks))
+
+	snap, err := s.Suspend(ctx, nil)
+	require.NoError(t, err)
+	assert.Len(t, snap.Tasks, 2)
+	assert.Empty(t, taskNames(cli, queuePath))
+
+	b, err := json.Marshal(snap)
+	require.NoError(t, err)
+	var restored scheduler.Snapshot
+	require.NoError(t, json.Unmarshal(b, &restored))
+	require.Len(t, restored.Tasks, 2)
+	body, err := io.ReadAll(restored.Tasks[0].Request.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"future"}`, string(body), "bodies are kept")
+	restored.Tasks[0].Request.Body = io.NopCloser(strings.NewReader(string(body)))
+
+	require.NoError(t, s.Resume(ctx, &restored))
+	require.NoError(t, s.Resume(ctx, &restored), "resume can be retried")
+	assert.Equal(t, []string{"test_future_" + strconv.FormatInt(future.UnixNano(), 16) + "v2"}, taskNames(cli, queuePath), "past tasks are not resumed")
+	got := cli.Server.Tasks(queuePath)
+	assert.Equal(t, `{"id":"future"}`, string(got[0].GetHttpRequest().Body))
+}
+
+func TestScheduler_SuspendResume_partialFailure(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+	future := time.Unix(4102444800, 0) // 2100-01-01
+	require.NoError(t, s.Sync(ctx, newPurgeTestTasks(ctx, queuePath, "test_", []string{"a", "b"}, future)))
+
+	cli.Server.SetFault(schedulertest.FailMethod("DeleteTask", codes.Internal, 1))
+	snap, err := s.Suspend(ctx, nil, scheduler.WithPurgeConcurrency(1))
+	require.Error(t, err)
+	require.Len(t, snap.Tasks, 2)
+	require.Len(t, taskNames(cli, queuePath), 1)
+	kept := taskNames(cli, queuePath)[0]
+
+	require.NoError(t, s.Resume(ctx, snap))
+	names := taskNames(cli, queuePath)
+	assert.Len(t, names, 2, "tasks which failed to be deleted are not duplicated")
+	assert.Contains(t, names, kept)
+}
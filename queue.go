@@ -0,0 +1,187 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	"github.com/googleapis/gax-go/v2"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/genproto/protobuf/field_mask"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+type QueueClient interface {
+	CloudTasksClient
+	GetQueue(ctx context.Context, req *taskspb.GetQueueRequest, opts ...gax.CallOption) (*taskspb.Queue, error)
+	CreateQueue(ctx context.Context, req *taskspb.CreateQueueRequest, opts ...gax.CallOption) (*taskspb.Queue, error)
+	UpdateQueue(ctx context.Context, req *taskspb.UpdateQueueRequest, opts ...gax.CallOption) (*taskspb.Queue, error)
+}
+
+var _ QueueClient = (*cloudtasks.Client)(nil)
+
+// QueueConfig is the declared configuration of a queue.
+// Only non-nil sections are managed, and zero fields in them are left to the current value,
+// except StackdriverLoggingConfig.SamplingRatio which is always managed if the section is set.
+type QueueConfig struct {
+	RateLimits               *taskspb.RateLimits
+	RetryConfig              *taskspb.RetryConfig
+	StackdriverLoggingConfig *taskspb.StackdriverLoggingConfig
+}
+
+// QueueChange is a field of the queue which differs from the declared configuration.
+type QueueChange struct {
+	// Field is the update mask path, e.g. "rate_limits.max_dispatches_per_second".
+	Field   string
+	Current string
+	Desired string
+}
+
+// QueuePlan is the mutation needed to make the queue match the declared configuration.
+type QueuePlan struct {
+	// Create is true if the queue doesn't exist.
+	Create  bool
+	Changes []QueueChange
+
+	queue *taskspb.Queue
+}
+
+func (p *QueuePlan) Empty() bool {
+	return !p.Create && len(p.Changes) == 0
+}
+
+type QueueManager struct {
+	client    QueueClient
+	queuePath string
+	config    QueueConfig
+}
+
+func NewQueueManager(client QueueClient, projectID, location, queue string, config QueueConfig) *QueueManager {
+	return &QueueManager{
+		client:    client,
+		queuePath: QueuePath(projectID, location, queue),
+		config:    config,
+	}
+}
+
+// Ensure makes the queue exist with the declared configuration.
+func (m *QueueManager) Ensure(ctx context.Context, opts ...gax.CallOption) (*QueuePlan, error) {
+	plan, err := m.Plan(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, m.Apply(ctx, plan, opts...)
+}
+
+// Plan compares the queue with the declared configuration and returns the mutation Ensure would apply.
+func (m *QueueManager) Plan(ctx context.Context, opts ...gax.CallOption) (*QueuePlan, error) {
+	current, err := m.client.GetQueue(ctx, &taskspb.GetQueueRequest{Name: m.queuePath}, opts...)
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			return nil, fmt.Errorf("failed to get queue: %w", err)
+		}
+
+		desired := &taskspb.Queue{Name: m.queuePath}
+		changes := m.merge(desired)
+		return &QueuePlan{Create: true, Changes: changes, queue: desired}, nil
+	}
+
+	desired := proto.Clone(current).(*taskspb.Queue)
+	changes := m.merge(desired)
+
+	return &QueuePlan{Changes: changes, queue: desired}, nil
+}
+
+// Apply creates or updates the queue as planned.
+func (m *QueueManager) Apply(ctx context.Context, plan *QueuePlan, opts ...gax.CallOption) error {
+	switch {
+	case plan.Create:
+		req := &taskspb.CreateQueueRequest{
+			Parent: m.queuePath[:strings.LastIndex(m.queuePath, "/queues/")],
+			Queue:  plan.queue,
+		}
+		if _, err := m.client.CreateQueue(ctx, req, opts...); err != nil {
+			return fmt.Errorf("failed to create queue: %w", err)
+		}
+	case len(plan.Changes) > 0:
+		paths := make([]string, 0, len(plan.Changes))
+		for _, c := range plan.Changes {
+			paths = append(paths, c.Field)
+		}
+		req := &taskspb.UpdateQueueRequest{
+			Queue:      plan.queue,
+			UpdateMask: &field_mask.FieldMask{Paths: paths},
+		}
+		if _, err := m.client.UpdateQueue(ctx, req, opts...); err != nil {
+			return fmt.Errorf("failed to update queue: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// merge overwrites q with the declared configuration and returns the changed fields.
+func (m *QueueManager) merge(q *taskspb.Queue) []QueueChange {
+	var changes []QueueChange
+	change := func(field string, current, desired interface{}) {
+		c, d := fmt.Sprint(current), fmt.Sprint(desired)
+		if c != d {
+			changes = append(changes, QueueChange{Field: field, Current: c, Desired: d})
+		}
+	}
+
+	if rl := m.config.RateLimits; rl != nil {
+		if q.RateLimits == nil {
+			q.RateLimits = &taskspb.RateLimits{}
+		}
+		if rl.MaxDispatchesPerSecond != 0 {
+			change("rate_limits.max_dispatches_per_second", q.RateLimits.MaxDispatchesPerSecond, rl.MaxDispatchesPerSecond)
+			q.RateLimits.MaxDispatchesPerSecond = rl.MaxDispatchesPerSecond
+		}
+		if rl.MaxConcurrentDispatches != 0 {
+			change("rate_limits.max_concurrent_dispatches", q.RateLimits.MaxConcurrentDispatches, rl.MaxConcurrentDispatches)
+			q.RateLimits.MaxConcurrentDispatches = rl.MaxConcurrentDispatches
+		}
+	}
+
+	if rc := m.config.RetryConfig; rc != nil {
+		if q.RetryConfig == nil {
+			q.RetryConfig = &taskspb.RetryConfig{}
+		}
+		if rc.MaxAttempts != 0 {
+			change("retry_config.max_attempts", q.RetryConfig.MaxAttempts, rc.MaxAttempts)
+			q.RetryConfig.MaxAttempts = rc.MaxAttempts
+		}
+		for _, d := range []struct {
+			field   string
+			current **durationpb.Duration
+			desired *durationpb.Duration
+		}{
+			{"retry_config.max_retry_duration", &q.RetryConfig.MaxRetryDuration, rc.MaxRetryDuration},
+			{"retry_config.min_backoff", &q.RetryConfig.MinBackoff, rc.MinBackoff},
+			{"retry_config.max_backoff", &q.RetryConfig.MaxBackoff, rc.MaxBackoff},
+		} {
+			if d.desired == nil {
+				continue
+			}
+			change(d.field, (*d.current).AsDuration(), d.desired.AsDuration())
+			*d.current = d.desired
+		}
+		if rc.MaxDoublings != 0 {
+			change("retry_config.max_doublings", q.RetryConfig.MaxDoublings, rc.MaxDoublings)
+			q.RetryConfig.MaxDoublings = rc.MaxDoublings
+		}
+	}
+
+	if lc := m.config.StackdriverLoggingConfig; lc != nil {
+		change("stackdriver_logging_config.sampling_ratio", q.GetStackdriverLoggingConfig().GetSamplingRatio(), lc.SamplingRatio)
+		q.StackdriverLoggingConfig = &taskspb.StackdriverLoggingConfig{SamplingRatio: lc.SamplingRatio}
+	}
+
+	return changes
+}
@@ -0,0 +1,67 @@
+package scheduler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/go-oss/scheduler"
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+func TestQueueManager(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx, schedulertest.WithStrictQueues())
+	require.NoError(t, err)
+	defer cli.Close()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	config := scheduler.QueueConfig{
+		RateLimits:               &taskspb.RateLimits{MaxDispatchesPerSecond: 10},
+		RetryConfig:              &taskspb.RetryConfig{MaxAttempts: 5, MinBackoff: durationpb.New(time.Second)},
+		StackdriverLoggingConfig: &taskspb.StackdriverLoggingConfig{SamplingRatio: 0.5},
+	}
+	m := scheduler.NewQueueManager(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", config)
+
+	plan, err := m.Plan(ctx)
+	require.NoError(t, err)
+	assert.True(t, plan.Create)
+	_, err = cli.GetQueue(ctx, &taskspb.GetQueueRequest{Name: queuePath})
+	assert.Error(t, err, "plan doesn't create the queue")
+
+	_, err = m.Ensure(ctx)
+	require.NoError(t, err)
+	q, err := cli.GetQueue(ctx, &taskspb.GetQueueRequest{Name: queuePath})
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, q.RateLimits.MaxDispatchesPerSecond)
+	assert.Equal(t, int32(5), q.RetryConfig.MaxAttempts)
+	assert.Equal(t, 0.5, q.StackdriverLoggingConfig.SamplingRatio)
+
+	plan, err = m.Plan(ctx)
+	require.NoError(t, err)
+	assert.True(t, plan.Empty(), "undeclared fields filled by defaults are not changes: %+v", plan.Changes)
+
+	config.RateLimits.MaxDispatchesPerSecond = 20
+	config.StackdriverLoggingConfig.SamplingRatio = 0
+	m = scheduler.NewQueueManager(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", config)
+	plan, err = m.Ensure(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []scheduler.QueueChange{
+		{Field: "rate_limits.max_dispatches_per_second", Current: "10", Desired: "20"},
+		{Field: "stackdriver_logging_config.sampling_ratio", Current: "0.5", Desired: "0"},
+	}, plan.Changes)
+
+	q, err = cli.GetQueue(ctx, &taskspb.GetQueueRequest{Name: queuePath})
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, q.RateLimits.MaxDispatchesPerSecond)
+	assert.Equal(t, int32(1000), q.RateLimits.MaxConcurrentDispatches, "undeclared fields are kept")
+	assert.Equal(t, int32(5), q.RetryConfig.MaxAttempts)
+	assert.Equal(t, 0.0, q.GetStackdriverLoggingConfig().GetSamplingRatio())
+}
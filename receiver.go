@@ -0,0 +1,222 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers set by Cloud Tasks on requests of HTTP target tasks.
+// see: https://cloud.google.com/tasks/docs/creating-http-target-tasks#handler
+const (
+	HeaderQueueName        = "X-CloudTasks-QueueName"
+	HeaderTaskName         = "X-CloudTasks-TaskName"
+	HeaderRetryCount       = "X-CloudTasks-TaskRetryCount"
+	HeaderExecutionCount   = "X-CloudTasks-TaskExecutionCount"
+	HeaderETA              = "X-CloudTasks-TaskETA"
+	HeaderPreviousResponse = "X-CloudTasks-TaskPreviousResponse"
+	HeaderRetryReason      = "X-CloudTasks-TaskRetryReason"
+)
+
+var (
+	ErrInvalidTaskRequest = errors.New("invalid task request")
+	ErrForeignTask        = errors.New("task of another prefix")
+)
+
+// TaskInfo is the metadata of the task which a request is delivered for.
+type TaskInfo struct {
+	QueueName string
+	// TaskName is the task id without the queue path.
+	TaskName    string
+	ID          string
+	Version     int
+	ScheduledAt time.Time
+	// ETA is the schedule time of the current attempt reported by Cloud Tasks.
+	ETA            time.Time
+	RetryCount     int
+	ExecutionCount int
+	// PreviousResponse is the HTTP status code of the previous attempt, or 0 on the first attempt.
+	PreviousResponse int
+	RetryReason      string
+}
+
+type taskInfoKey struct{}
+
+func ContextWithTaskInfo(ctx context.Context, info *TaskInfo) context.Context {
+	return context.WithValue(ctx, taskInfoKey{}, info)
+}
+
+// TaskInfoFromContext returns the TaskInfo stored by Receiver.
+func TaskInfoFromContext(ctx context.Context) (*TaskInfo, bool) {
+	info, ok := ctx.Value(taskInfoKey{}).(*TaskInfo)
+	return info, ok
+}
+
+// ParseTaskInfo decodes the Cloud Tasks headers of a request for a task created with prefix.
+func ParseTaskInfo(prefix string, h http.Header) (*TaskInfo, error) {
+	taskName := h.Get(HeaderTaskName)
+	if taskName == "" {
+		return nil, fmt.Errorf("%s header is missing: %w", HeaderTaskName, ErrInvalidTaskRequest)
+	}
+
+	if !strings.HasPrefix(path.Base(taskName), prefix) {
+		return nil, fmt.Errorf("task %s is not under prefix %q: %w", taskName, prefix, ErrForeignTask)
+	}
+
+	id, scheduledAt, version, err := parseTaskName(prefix, taskName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse task name %s: %v: %w", taskName, err, ErrInvalidTaskRequest)
+	}
+
+	info := &TaskInfo{
+		QueueName:   h.Get(HeaderQueueName),
+		TaskName:    taskName,
+		ID:          id,
+		Version:     version,
+		ScheduledAt: scheduledAt,
+		RetryReason: h.Get(HeaderRetryReason),
+	}
+
+	for _, f := range []struct {
+		header string
+		dst    *int
+	}{
+		{HeaderRetryCount, &info.RetryCount},
+		{HeaderExecutionCount, &info.ExecutionCount},
+		{HeaderPreviousResponse, &info.PreviousResponse},
+	} {
+		v := h.Get(f.header)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header (%s): %w", f.header, v, ErrInvalidTaskRequest)
+		}
+		*f.dst = n
+	}
+
+	if v := h.Get(HeaderETA); v != "" {
+		eta, err := parseETA(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header (%s): %w", HeaderETA, v, ErrInvalidTaskRequest)
+		}
+		info.ETA = eta
+	}
+
+	return info, nil
+}
+
+// parseETA parses seconds since the epoch with an optional fraction, e.g. "1669852800.123456".
+func parseETA(v string) (time.Time, error) {
+	sec, frac := v, ""
+	if idx := strings.IndexByte(v, '.'); idx >= 0 {
+		sec, frac = v[:idx], v[idx+1:]
+	}
+
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var nsec int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		frac += strings.Repeat("0", 9-len(frac))
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return time.Unix(s, nsec), nil
+}
+
+type ReceiverOption func(*Receiver)
+
+// WithVersionSource makes Receiver acknowledge deliveries of superseded versions with 200 OK
+// without calling the next handler.
+func WithVersionSource(src VersionSource) ReceiverOption {
+	return func(r *Receiver) {
+		r.versions = src
+	}
+}
+
+// WithForeignTaskStatus sets the status code of the response to tasks of other prefixes. The default is 200 OK.
+// Cloud Tasks retries a task until it gets a 2xx response, so other codes make such tasks retried until max attempts.
+func WithForeignTaskStatus(code int) ReceiverOption {
+	return func(r *Receiver) {
+		r.foreignStatus = code
+	}
+}
+
+// WithReceiverErrorHandler sets the handler for requests which are rejected.
+// By default they are answered with 400 Bad Request if the request is invalid,
+// the status of WithForeignTaskStatus if the task is of another prefix, otherwise 500 Internal Server Error.
+func WithReceiverErrorHandler(f func(w http.ResponseWriter, r *http.Request, err error)) ReceiverOption {
+	return func(r *Receiver) {
+		r.errorHandler = f
+	}
+}
+
+// Receiver is a middleware for handlers of tasks created by Scheduler.
+// It decodes the Cloud Tasks headers into a TaskInfo stored in the request context,
+// and rejects requests whose task name does not match the prefix.
+type Receiver struct {
+	prefix        string
+	versions      VersionSource
+	foreignStatus int
+	errorHandler  func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+func NewReceiver(prefix string, opts ...ReceiverOption) *Receiver {
+	r := &Receiver{
+		prefix:        prefix,
+		foreignStatus: http.StatusOK,
+	}
+	r.errorHandler = func(w http.ResponseWriter, _ *http.Request, err error) {
+		code := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrInvalidTaskRequest):
+			code = http.StatusBadRequest
+		case errors.Is(err, ErrForeignTask):
+			code = r.foreignStatus
+		}
+		http.Error(w, err.Error(), code)
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func (rc *Receiver) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, err := ParseTaskInfo(rc.prefix, r.Header)
+		if err != nil {
+			rc.errorHandler(w, r, err)
+			return
+		}
+
+		if rc.versions != nil {
+			superseded, err := IsSuperseded(r.Context(), rc.versions, info)
+			if err != nil {
+				rc.errorHandler(w, r, err)
+				return
+			}
+			if superseded {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(ContextWithTaskInfo(r.Context(), info)))
+	})
+}
@@ -0,0 +1,150 @@
+package scheduler_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oss/scheduler"
+)
+
+func TestParseTaskInfo(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		header  map[string]string
+		want    *scheduler.TaskInfo
+		wantErr error
+	}{
+		{
+			name: "first attempt",
+			header: map[string]string{
+				"X-CloudTasks-QueueName":          "scheduler",
+				"X-CloudTasks-TaskName":           "test_id_3b9aca02v2",
+				"X-CloudTasks-TaskRetryCount":     "0",
+				"X-CloudTasks-TaskExecutionCount": "0",
+				"X-CloudTasks-TaskETA":            "1.000000002",
+			},
+			want: &scheduler.TaskInfo{
+				QueueName:   "scheduler",
+				TaskName:    "test_id_3b9aca02v2",
+				ID:          "id",
+				Version:     2,
+				ScheduledAt: time.Unix(1, 2),
+				ETA:         time.Unix(1, 2),
+			},
+		},
+		{
+			name: "retry",
+			header: map[string]string{
+				"X-CloudTasks-QueueName":            "scheduler",
+				"X-CloudTasks-TaskName":             "test_id_3b9aca02v1",
+				"X-CloudTasks-TaskRetryCount":       "3",
+				"X-CloudTasks-TaskExecutionCount":   "2",
+				"X-CloudTasks-TaskETA":              "10.5",
+				"X-CloudTasks-TaskPreviousResponse": "503",
+				"X-CloudTasks-TaskRetryReason":      "HTTP_RESPONSE_CODE",
+			},
+			want: &scheduler.TaskInfo{
+				QueueName:        "scheduler",
+				TaskName:         "test_id_3b9aca02v1",
+				ID:               "id",
+				Version:          1,
+				ScheduledAt:      time.Unix(1, 2),
+				ETA:              time.Unix(10, 500000000),
+				RetryCount:       3,
+				ExecutionCount:   2,
+				PreviousResponse: 503,
+				RetryReason:      "HTTP_RESPONSE_CODE",
+			},
+		},
+		{
+			name:    "not a task request",
+			header:  map[string]string{},
+			wantErr: scheduler.ErrInvalidTaskRequest,
+		},
+		{
+			name: "unmatched prefix",
+			header: map[string]string{
+				"X-CloudTasks-TaskName": "other_id_3b9aca02v1",
+			},
+			wantErr: scheduler.ErrForeignTask,
+		},
+		{
+			name: "invalid retry count",
+			header: map[string]string{
+				"X-CloudTasks-TaskName":       "test_id_3b9aca02v1",
+				"X-CloudTasks-TaskRetryCount": "many",
+			},
+			wantErr: scheduler.ErrInvalidTaskRequest,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := http.Header{}
+			for k, v := range tt.header {
+				h.Set(k, v)
+			}
+
+			got, err := scheduler.ParseTaskInfo("test_", h)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got: %v, want: %v", err, tt.wantErr)
+			}
+			if tt.want != nil {
+				assert.True(t, tt.want.ScheduledAt.Equal(got.ScheduledAt))
+				assert.True(t, tt.want.ETA.Equal(got.ETA))
+				tt.want.ScheduledAt, tt.want.ETA = got.ScheduledAt, got.ETA
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReceiver_Handler(t *testing.T) {
+	t.Parallel()
+
+	var got *scheduler.TaskInfo
+	h := scheduler.NewReceiver("test_").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := scheduler.TaskInfoFromContext(r.Context())
+		require.True(t, ok)
+		got = info
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("X-CloudTasks-TaskName", "test_id_3b9aca02v1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.NotNil(t, got)
+	assert.Equal(t, "id", got.ID)
+
+	got = nil
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("X-CloudTasks-TaskName", "other_id_3b9aca02v1")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "tasks of other prefixes are acknowledged not to be retried")
+	assert.Nil(t, got)
+
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("X-CloudTasks-TaskName", "test_id")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	h = scheduler.NewReceiver("test_", scheduler.WithForeignTaskStatus(http.StatusNotFound)).Handler(http.NotFoundHandler())
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("X-CloudTasks-TaskName", "other_id_3b9aca02v1")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
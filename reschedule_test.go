@@ -0,0 +1,127 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-oss/scheduler"
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+func TestReschedule(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+	token := &scheduler.OIDCToken{ServiceAccountEmail: "invoker@example.com"}
+
+	h := scheduler.NewReceiver("test_").Handler(scheduler.NewRescheduler(s, scheduler.WithRescheduleAuthorization(token)).Handler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			task, err := scheduler.Reschedule(r.Context(), time.Minute)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			assert.Equal(t, "test_poll_df8475800v2", task.TaskID())
+		}),
+	))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "https://example.com/poll", strings.NewReader(`{"job":"1"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-CloudTasks-TaskName", "test_poll_0v2")
+		req.Header.Set("X-CloudTasks-TaskRetryCount", "1")
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	tasks := cli.Server.Tasks(queuePath)
+	require.Len(t, tasks, 1, "retries of the delivery don't create duplicates")
+	assert.Equal(t, "test_poll_df8475800v2", path.Base(tasks[0].Name))
+	assert.Equal(t, time.Unix(60, 0).UTC(), tasks[0].ScheduleTime.AsTime())
+	hr := tasks[0].GetHttpRequest()
+	assert.Equal(t, "https://example.com/poll", hr.Url)
+	assert.Equal(t, `{"job":"1"}`, string(hr.Body))
+	assert.Equal(t, map[string]string{"Content-Type": "application/json"}, hr.Headers)
+	assert.Equal(t, "invoker@example.com", hr.GetOidcToken().ServiceAccountEmail)
+}
+
+func TestReschedule_sync(t *testing.T) {
+	t.Parallel()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{
+			name:   "same prefix",
+			prefix: "test_",
+			want:   []string{"test_poll_0v1"},
+		},
+		{
+			name:   "own prefix",
+			prefix: "followup_",
+			want:   []string{"followup_poll_df8475800v1", "test_poll_0v1"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			cli, err := schedulertest.NewFakeClient(ctx)
+			require.NoError(t, err)
+			defer cli.Close()
+
+			s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://example.com/poll", nil)
+			require.NoError(t, err)
+			desired := []*scheduler.Task{{QueuePath: queuePath, Prefix: "test_", ID: "poll", ScheduledAt: time.Unix(0, 0), Request: req}}
+			require.NoError(t, s.Sync(ctx, desired))
+
+			followup := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", tt.prefix)
+			h := scheduler.NewReceiver("test_").Handler(scheduler.NewRescheduler(followup).Handler(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if _, err := scheduler.Reschedule(r.Context(), time.Minute); err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+					}
+				}),
+			))
+			r := httptest.NewRequest(http.MethodPost, "https://example.com/poll", nil)
+			r.Header.Set("X-CloudTasks-TaskName", "test_poll_0v1")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			require.Len(t, cli.Server.Tasks(queuePath), 2)
+
+			require.NoError(t, s.Sync(ctx, desired))
+			assert.Equal(t, tt.want, taskNames(cli, queuePath))
+		})
+	}
+}
+
+func TestReschedule_notReschedulable(t *testing.T) {
+	t.Parallel()
+
+	_, err := scheduler.Reschedule(context.Background(), time.Minute)
+	assert.True(t, errors.Is(err, scheduler.ErrNotReschedulable))
+}
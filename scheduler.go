@@ -6,23 +6,40 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"iter"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	"github.com/googleapis/gax-go/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 var (
 	ErrTaskValidation    = errors.New("task validation error")
 	ErrTaskAlreadyExists = errors.New("task already exists")
+	ErrTaskNotFound      = errors.New("task not found")
+	ErrTaskDispatched    = errors.New("task already dispatched")
+	ErrTaskConflict      = errors.New("task conflicts with existing task")
 )
 
+// maxMoveVersions is the number of versions Move tries to find a free task name.
+const maxMoveVersions = 10
+
 type CloudTasksClient interface {
 	ListTasks(ctx context.Context, req *taskspb.ListTasksRequest, opts ...gax.CallOption) *cloudtasks.TaskIterator
 	CreateTask(ctx context.Context, req *taskspb.CreateTaskRequest, opts ...gax.CallOption) (*taskspb.Task, error)
 	DeleteTask(ctx context.Context, req *taskspb.DeleteTaskRequest, opts ...gax.CallOption) error
+	RunTask(ctx context.Context, req *taskspb.RunTaskRequest, opts ...gax.CallOption) (*taskspb.Task, error)
+	GetTask(ctx context.Context, req *taskspb.GetTaskRequest, opts ...gax.CallOption) (*taskspb.Task, error)
 }
 
 var _ CloudTasksClient = (*cloudtasks.Client)(nil)
@@ -32,49 +49,159 @@ type Scheduler struct {
 	queuePath string
 	prefix    string
 	iterator  func(...gax.CallOption) *Iterator
+	registry  *VersionRegistry
+	malformed MalformedTaskPolicy
+	cleanup   bool
+	prefetch  int
+
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	metrics    Metrics
+	logger     *slog.Logger
+	redaction  RedactionPolicy
+}
+
+type Option func(*Scheduler)
+
+// WithVersionRegistry makes Apply record the versions of the tasks in registry.
+func WithVersionRegistry(registry *VersionRegistry) Option {
+	return func(s *Scheduler) {
+		s.registry = registry
+	}
+}
+
+// WithMalformedTaskPolicy sets the policy for malformed tasks under the prefix found while listing.
+// The default is FailOnMalformedTask.
+func WithMalformedTaskPolicy(policy MalformedTaskPolicy) Option {
+	return func(s *Scheduler) {
+		s.malformed = policy
+	}
+}
+
+// WithForeignTaskCleanup makes Sync delete the malformed tasks under the prefix,
+// such as leftovers with broken names, instead of applying the MalformedTaskPolicy.
+func WithForeignTaskCleanup() Option {
+	return func(s *Scheduler) {
+		s.cleanup = true
+	}
+}
+
+// WithPrefetch makes the iterators of the scheduler, including the one of Sync,
+// fetch up to pages pages in the background. See Iterator.WithPrefetch.
+// Iterators returned by List and ListFrom then leak a goroutine if they are abandoned before Done without Close,
+// and their filters and malformed task policies are called on that goroutine instead of the caller's.
+func WithPrefetch(pages int) Option {
+	return func(s *Scheduler) {
+		s.prefetch = pages
+	}
 }
 
 func QueuePath(projectID, location, queue string) string {
 	return "projects/" + projectID + "/locations/" + location + "/queues/" + queue
 }
 
-func New(client CloudTasksClient, projectID, location, queue, prefix string) *Scheduler {
+func New(client CloudTasksClient, projectID, location, queue, prefix string, opts ...Option) *Scheduler {
 	queuePath := QueuePath(projectID, location, queue)
-	return &Scheduler{
-		client:    client,
-		queuePath: queuePath,
-		prefix:    prefix,
-		iterator: func(opts ...gax.CallOption) *Iterator {
-			return NewIterator(TaskListerFunc(client.ListTasks), queuePath, prefix, opts...)
-		},
+	s := &Scheduler{
+		client:     client,
+		queuePath:  queuePath,
+		prefix:     prefix,
+		propagator: propagation.TraceContext{},
 	}
+	s.iterator = func(opts ...gax.CallOption) *Iterator {
+		it := NewIterator(TaskListerFunc(client.ListTasks), queuePath, prefix, opts...)
+		it.tracer = s.tracer
+		it.metrics = s.metrics
+		it.malformed = s.malformed
+		return it.WithPrefetch(s.prefetch)
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
-func (s *Scheduler) Sync(ctx context.Context, tasks []*Task, opts ...gax.CallOption) error {
+// Plan is the set of mutations needed to make the remote tasks match the desired tasks.
+// Foreign is the names of the malformed tasks under the prefix to delete, planned with WithForeignTaskCleanup.
+type Plan struct {
+	Create    []*Task
+	Delete    []*Task
+	Unchanged []*Task
+	Foreign   []string
+}
+
+func (p *Plan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Delete) == 0 && len(p.Foreign) == 0
+}
+
+func (s *Scheduler) Sync(ctx context.Context, tasks []*Task, opts ...gax.CallOption) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "scheduler.Sync", s.attributes()...)
+	defer func() {
+		outcome := OutcomeOK
+		if err != nil {
+			outcome = OutcomeError
+		}
+		endSpan(span, outcome, err)
+	}()
+
+	plan, err := s.Plan(ctx, tasks, opts...)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(
+		attribute.Int("scheduler.sync.create", len(plan.Create)),
+		attribute.Int("scheduler.sync.delete", len(plan.Delete)),
+		attribute.Int("scheduler.sync.unchanged", len(plan.Unchanged)),
+		attribute.Int("scheduler.sync.foreign", len(plan.Foreign)),
+	)
+	s.addTasks(ctx, OutcomeUnchanged, len(plan.Unchanged))
+
+	return s.Apply(ctx, plan, opts...)
+}
+
+func (s *Scheduler) attributes(attrs ...attribute.KeyValue) []attribute.KeyValue {
+	return append([]attribute.KeyValue{
+		AttributeQueue.String(s.queuePath),
+		AttributePrefix.String(s.prefix),
+	}, attrs...)
+}
+
+// Plan compares tasks with the remote tasks and returns the mutations Sync would apply.
+// Versions of tasks which need to be updated are bumped over the remote ones.
+func (s *Scheduler) Plan(ctx context.Context, tasks []*Task, opts ...gax.CallOption) (*Plan, error) {
 	taskMap := make(map[string]*Task, len(tasks))
 	for _, t := range tasks {
 		taskMap[t.comparisonID()] = t
 	}
 
+	plan := &Plan{}
 	iter := s.List(opts...)
-	var deleteNames []string
+	defer iter.Close()
+	if s.cleanup {
+		iter.WithMalformedTaskPolicy(ReportMalformedTask(func(name string, err error) {
+			s.log(ctx, slog.LevelWarn, "planned malformed task deletion", slog.String("task", name), errorAttr(err))
+			plan.Foreign = append(plan.Foreign, name)
+		}))
+	}
 	for {
 		remoteTask, err := iter.Next(ctx)
 		if err != nil {
 			if errors.Is(err, Done) {
 				break
 			}
-			return fmt.Errorf("failed to iterate remoteTasks: %w", err)
+			return nil, fmt.Errorf("failed to iterate remoteTasks: %w", err)
 		}
 
 		if t, ok := taskMap[remoteTask.comparisonID()]; ok {
 			if t.Compare(remoteTask) {
 				delete(taskMap, t.comparisonID())
+				plan.Unchanged = append(plan.Unchanged, remoteTask)
 				continue
 			}
 
 			// delete remote task to update it
-			deleteNames = append(deleteNames, remoteTask.TaskName())
+			plan.Delete = append(plan.Delete, remoteTask)
 			if t.Version <= remoteTask.Version {
 				t.Version = remoteTask.Version + 1
 			}
@@ -82,37 +209,139 @@ func (s *Scheduler) Sync(ctx context.Context, tasks []*Task, opts ...gax.CallOpt
 		}
 
 		// delete remote task
-		deleteNames = append(deleteNames, remoteTask.TaskName())
+		plan.Delete = append(plan.Delete, remoteTask)
 	}
 
-	for _, name := range deleteNames {
+	for _, t := range tasks {
+		if _, ok := taskMap[t.comparisonID()]; !ok {
+			continue
+		}
+		plan.Create = append(plan.Create, t)
+	}
+
+	for _, t := range plan.Delete {
+		s.log(ctx, slog.LevelDebug, "planned task deletion", s.taskAttr(t))
+	}
+	for _, t := range plan.Create {
+		s.log(ctx, slog.LevelDebug, "planned task creation", s.taskAttr(t))
+	}
+
+	return plan, nil
+}
+
+// Apply deletes and then creates the tasks of plan.
+// The deleted versions are forgotten by the VersionRegistry after the created ones are recorded,
+// so that the registry has a version of updated tasks all the time.
+func (s *Scheduler) Apply(ctx context.Context, plan *Plan, opts ...gax.CallOption) error {
+	for _, name := range plan.Foreign {
 		if err := s.Delete(ctx, name, opts...); err != nil {
 			return err
 		}
 	}
 
-	for _, t := range tasks {
-		if _, ok := taskMap[t.comparisonID()]; !ok {
-			continue
+	var deleted []*Task
+	if s.registry != nil {
+		defer func() {
+			for _, t := range deleted {
+				s.registry.Forget(t)
+			}
+		}()
+	}
+	for _, t := range plan.Delete {
+		if err := s.Delete(ctx, t.TaskName(), opts...); err != nil {
+			return err
 		}
+		deleted = append(deleted, t)
+	}
 
+	for _, t := range plan.Create {
 		if err := s.Create(ctx, t, opts...); err != nil {
 			return err
 		}
+		if s.registry != nil {
+			s.registry.Record(t)
+		}
+	}
+
+	if s.registry != nil {
+		for _, t := range plan.Unchanged {
+			s.registry.Record(t)
+		}
 	}
 
 	return nil
 }
 
+// List returns an iterator of the tasks of the prefix.
+// Close must be called if the iteration is abandoned before Done when WithPrefetch is set.
 func (s *Scheduler) List(opts ...gax.CallOption) *Iterator {
 	return s.iterator(opts...)
 }
 
-func (s *Scheduler) Create(ctx context.Context, task *Task, opts ...gax.CallOption) error {
+// ListFrom returns an iterator which resumes listing from pageToken.
+func (s *Scheduler) ListFrom(pageToken string, opts ...gax.CallOption) *Iterator {
+	it := s.iterator(opts...)
+	it.pageToken, it.pageStart = pageToken, pageToken
+	return it
+}
+
+// ListPage returns the tasks of the page of pageToken, and the token of the next page.
+// An empty pageToken lists the first page, and an empty next token means the last page.
+func (s *Scheduler) ListPage(ctx context.Context, pageToken string, pageSize int, opts ...gax.CallOption) ([]*Task, string, error) {
+	it := s.ListFrom(pageToken, opts...).WithPageSize(pageSize)
+	defer it.Close()
+	// a single page is not worth fetching the following ones in the background
+	it.prefetch = 0
+	tasks, next, err := it.NextPage(ctx)
+	if errors.Is(err, Done) {
+		return []*Task{}, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return tasks, next, nil
+}
+
+// All returns the tasks of the prefix as an iterator to range over.
+func (s *Scheduler) All(ctx context.Context, opts ...gax.CallOption) iter.Seq2[*Task, error] {
+	return s.List(opts...).All(ctx)
+}
+
+// Pages returns the tasks of the prefix page by page as an iterator to range over.
+func (s *Scheduler) Pages(ctx context.Context, opts ...gax.CallOption) iter.Seq2[[]*Task, error] {
+	return s.List(opts...).Pages(ctx)
+}
+
+func (s *Scheduler) Create(ctx context.Context, task *Task, opts ...gax.CallOption) (err error) {
 	if task.Version == 0 {
 		task.Version = 1
 	}
 
+	ctx, span := startSpan(ctx, s.tracer, "scheduler.Create", s.attributes(
+		AttributeTaskID.String(task.ID),
+		AttributeTaskVersion.Int(task.Version),
+	)...)
+	defer func() {
+		outcome := OutcomeCreated
+		switch {
+		case errors.Is(err, ErrTaskAlreadyExists):
+			outcome = OutcomeAlreadyExists
+		case err != nil:
+			outcome = OutcomeError
+		}
+		endSpan(span, outcome, err)
+		s.addTasks(ctx, outcome, 1)
+		switch outcome {
+		case OutcomeCreated:
+			s.log(ctx, slog.LevelInfo, "created task", s.taskAttr(task))
+		case OutcomeAlreadyExists:
+			s.log(ctx, slog.LevelInfo, "task already exists", s.taskAttr(task))
+		default:
+			s.log(ctx, slog.LevelError, "failed to create task", s.taskAttr(task), errorAttr(err))
+		}
+	}()
+
 	if err := task.Validate(); err != nil {
 		return err
 	}
@@ -121,13 +350,19 @@ func (s *Scheduler) Create(ctx context.Context, task *Task, opts ...gax.CallOpti
 	if err != nil {
 		return err
 	}
+	if s.tracer != nil {
+		s.propagator.Inject(ctx, propagation.MapCarrier(t.GetHttpRequest().Headers))
+	}
 
 	req := &taskspb.CreateTaskRequest{
 		Parent:       task.QueuePath,
 		Task:         t,
 		ResponseView: taskspb.Task_BASIC,
 	}
-	if _, err := s.client.CreateTask(ctx, req, opts...); err != nil {
+	start := time.Now()
+	_, err = s.client.CreateTask(ctx, req, opts...)
+	s.recordRPC(ctx, "CreateTask", start, err)
+	if err != nil {
 		switch status.Code(err) {
 		case codes.AlreadyExists:
 			return ErrTaskAlreadyExists
@@ -139,13 +374,267 @@ func (s *Scheduler) Create(ctx context.Context, task *Task, opts ...gax.CallOpti
 	return nil
 }
 
-func (s *Scheduler) Delete(ctx context.Context, taskName string, opts ...gax.CallOption) error {
+func (s *Scheduler) Delete(ctx context.Context, taskName string, opts ...gax.CallOption) (err error) {
+	attrs := s.attributes()
+	if id, version, err := ParseTaskName(s.prefix, taskName); err == nil {
+		attrs = append(attrs, AttributeTaskID.String(id), AttributeTaskVersion.Int(version))
+	}
+	ctx, span := startSpan(ctx, s.tracer, "scheduler.Delete", attrs...)
+	defer func() {
+		outcome := OutcomeDeleted
+		if err != nil {
+			outcome = OutcomeError
+		}
+		endSpan(span, outcome, err)
+		s.addTasks(ctx, outcome, 1)
+		if err != nil {
+			s.log(ctx, slog.LevelError, "failed to delete task", slog.String("task", taskName), errorAttr(err))
+		} else {
+			s.log(ctx, slog.LevelInfo, "deleted task", slog.String("task", taskName))
+		}
+	}()
+
 	req := &taskspb.DeleteTaskRequest{
 		Name: taskName,
 	}
-	if err := s.client.DeleteTask(ctx, req, opts...); err != nil {
+	start := time.Now()
+	err = s.client.DeleteTask(ctx, req, opts...)
+	s.recordRPC(ctx, "DeleteTask", start, err)
+	if err != nil {
 		return fmt.Errorf("failed to delete task: %w", err)
 	}
 
 	return nil
 }
+
+// RunNow dispatches the current version of the task identified by id and scheduledAt immediately.
+// It returns the name of the dispatched task.
+func (s *Scheduler) RunNow(ctx context.Context, id string, scheduledAt time.Time, opts ...gax.CallOption) (string, error) {
+	return s.run(ctx, id, func(t *Task) bool { return t.ScheduledAt.Equal(scheduledAt) }, opts...)
+}
+
+// RunNext dispatches the current version of the earliest scheduled task of id immediately.
+// It returns the name of the dispatched task.
+func (s *Scheduler) RunNext(ctx context.Context, id string, opts ...gax.CallOption) (string, error) {
+	return s.run(ctx, id, nil, opts...)
+}
+
+func (s *Scheduler) run(ctx context.Context, id string, match func(*Task) bool, opts ...gax.CallOption) (string, error) {
+	tasks, err := s.FindByID(ctx, id, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	// tasks are ordered by scheduled time and version, so the latest version of the first occurrence is run.
+	var target *Task
+	for _, t := range tasks {
+		if match != nil && !match(t) {
+			continue
+		}
+		if target != nil && !t.ScheduledAt.Equal(target.ScheduledAt) {
+			break
+		}
+		target = t
+	}
+	if target == nil {
+		return "", fmt.Errorf("%s: %w", id, ErrTaskNotFound)
+	}
+
+	req := &taskspb.RunTaskRequest{
+		Name:         target.TaskName(),
+		ResponseView: taskspb.Task_BASIC,
+	}
+	if _, err := s.client.RunTask(ctx, req, opts...); err != nil {
+		return "", fmt.Errorf("failed to run task: %w", err)
+	}
+
+	return target.TaskName(), nil
+}
+
+// Get returns the latest version of the task identified by id and scheduledAt with its request body.
+// The version is resolved from the VersionRegistry if any, otherwise by listing the tasks.
+func (s *Scheduler) Get(ctx context.Context, id string, scheduledAt time.Time, opts ...gax.CallOption) (*Task, error) {
+	if s.registry != nil {
+		if v, ok, _ := s.registry.CurrentVersion(ctx, id, scheduledAt); ok {
+			t := &Task{QueuePath: s.queuePath, Prefix: s.prefix, ID: id, ScheduledAt: scheduledAt, Version: v}
+			task, err := s.getTask(ctx, t.TaskName(), opts...)
+			if !errors.Is(err, ErrTaskNotFound) {
+				return task, err
+			}
+		}
+	}
+
+	tasks, err := s.FindByID(ctx, id, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var latest *Task
+	for _, t := range tasks {
+		if t.ScheduledAt.Equal(scheduledAt) {
+			latest = t
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("%s at %s: %w", id, scheduledAt, ErrTaskNotFound)
+	}
+
+	return s.getTask(ctx, latest.TaskName(), opts...)
+}
+
+// FindByID returns every occurrence and version of the tasks of id ordered by scheduled time and version.
+// Request bodies are not included.
+func (s *Scheduler) FindByID(ctx context.Context, id string, opts ...gax.CallOption) ([]*Task, error) {
+	iter := s.List(opts...).WithIDFilter(func(taskID string) bool { return taskID == id })
+	tasks, err := s.collect(ctx, iter, nil)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		if !tasks[i].ScheduledAt.Equal(tasks[j].ScheduledAt) {
+			return tasks[i].ScheduledAt.Before(tasks[j].ScheduledAt)
+		}
+		return tasks[i].Version < tasks[j].Version
+	})
+
+	return tasks, nil
+}
+
+func (s *Scheduler) getTask(ctx context.Context, taskName string, opts ...gax.CallOption) (*Task, error) {
+	pb, err := s.getPbTask(ctx, taskName, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return PbTaskToTask(ctx, s.queuePath, s.prefix, pb)
+}
+
+func (s *Scheduler) getPbTask(ctx context.Context, taskName string, opts ...gax.CallOption) (*taskspb.Task, error) {
+	req := &taskspb.GetTaskRequest{
+		Name:         taskName,
+		ResponseView: taskspb.Task_FULL,
+	}
+	pb, err := s.client.GetTask(ctx, req, opts...)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%s: %w", taskName, ErrTaskNotFound)
+		}
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+
+	return pb, nil
+}
+
+// Move reschedules the task identified by id from one scheduled time to another, and returns the moved task.
+// The task at to is created before the task at from is deleted, so the task never disappears in between.
+// It returns ErrTaskNotFound if the task at from doesn't exist, and ErrTaskDispatched if it has been dispatched.
+// Move can be retried: a task already created at to is reused if it has the same request,
+// otherwise ErrTaskConflict is returned, and a retry after the task at from has been deleted returns the task at to.
+func (s *Scheduler) Move(ctx context.Context, id string, from, to time.Time, opts ...gax.CallOption) (*Task, error) {
+	if from.Equal(to) {
+		return nil, fmt.Errorf("%s is moved to the same time %s: %w", id, to, ErrTaskValidation)
+	}
+
+	tasks, err := s.FindByID(ctx, id, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// tasks are ordered by version, so the latest versions are picked.
+	var src, dst *Task
+	for _, t := range tasks {
+		switch {
+		case t.ScheduledAt.Equal(from):
+			src = t
+		case t.ScheduledAt.Equal(to):
+			dst = t
+		}
+	}
+	if src == nil {
+		if dst != nil {
+			return s.getTask(ctx, dst.TaskName(), opts...)
+		}
+		return nil, fmt.Errorf("%s at %s: %w", id, from, ErrTaskNotFound)
+	}
+
+	pb, err := s.getPbTask(ctx, src.TaskName(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	if pb.GetDispatchCount() > 0 {
+		return nil, fmt.Errorf("%s: %w", src.TaskName(), ErrTaskDispatched)
+	}
+	task, err := PbTaskToTask(ctx, s.queuePath, s.prefix, pb)
+	if err != nil {
+		return nil, err
+	}
+
+	var moved *Task
+	if dst != nil {
+		// the task at to is reused whatever its version is, not to run the task twice
+		dstPb, err := s.getPbTask(ctx, dst.TaskName(), opts...)
+		if err != nil {
+			return nil, err
+		}
+		if !s.sameRequest(pb.GetHttpRequest(), dstPb.GetHttpRequest()) {
+			return nil, fmt.Errorf("%s: %w", dst.TaskName(), ErrTaskConflict)
+		}
+		if moved, err = PbTaskToTask(ctx, s.queuePath, s.prefix, dstPb); err != nil {
+			return nil, err
+		}
+	} else {
+		moved = task
+		moved.ScheduledAt = to
+		if err := s.createMoved(ctx, moved, opts...); err != nil {
+			return nil, err
+		}
+	}
+	if s.registry != nil {
+		s.registry.Record(moved)
+	}
+
+	if err := s.Delete(ctx, src.TaskName(), opts...); err != nil && status.Code(errors.Unwrap(err)) != codes.NotFound {
+		return nil, err
+	}
+	if s.registry != nil {
+		s.registry.Forget(src)
+	}
+
+	return moved, nil
+}
+
+// sameRequest reports whether a and b are the same request, ignoring the headers injected by the propagator.
+func (s *Scheduler) sameRequest(a, b *taskspb.HttpRequest) bool {
+	a, b = proto.Clone(a).(*taskspb.HttpRequest), proto.Clone(b).(*taskspb.HttpRequest)
+	for _, f := range s.propagator.Fields() {
+		for k := range a.GetHeaders() {
+			if strings.EqualFold(k, f) {
+				delete(a.Headers, k)
+			}
+		}
+		for k := range b.GetHeaders() {
+			if strings.EqualFold(k, f) {
+				delete(b.Headers, k)
+			}
+		}
+	}
+
+	return proto.Equal(a, b)
+}
+
+// createMoved creates t, or keeps it if it already exists.
+// Names of deleted tasks can't be reused for a while, so the version is bumped until the name is free,
+// up to maxMoveVersions times.
+func (s *Scheduler) createMoved(ctx context.Context, t *Task, opts ...gax.CallOption) error {
+	for i := 0; i < maxMoveVersions; i++ {
+		err := s.Create(ctx, t, opts...)
+		if !errors.Is(err, ErrTaskAlreadyExists) {
+			return err
+		}
+		if _, err := s.getPbTask(ctx, t.TaskName(), opts...); !errors.Is(err, ErrTaskNotFound) {
+			return err
+		}
+		t.Version++
+	}
+
+	return fmt.Errorf("no free name for %s in %d versions: %w", t.ID, maxMoveVersions, ErrTaskAlreadyExists)
+}
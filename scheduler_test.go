@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
 	"net/http"
+	"path"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/googleapis/gax-go/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/api/iterator"
 	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
 	"google.golang.org/grpc/codes"
@@ -19,6 +22,7 @@ import (
 
 	"github.com/go-oss/scheduler"
 	mock_scheduler "github.com/go-oss/scheduler/mock"
+	"github.com/go-oss/scheduler/schedulertest"
 )
 
 func TestScheduler_Sync(t *testing.T) {
@@ -293,6 +297,134 @@ func TestScheduler_Sync(t *testing.T) {
 	}
 }
 
+func TestScheduler_Sync_fakeClient(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	newTasks := func(url string) []*scheduler.Task {
+		var tasks []*scheduler.Task
+		for i := 0; i < 3; i++ {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader([]byte(`{"payload":"test"}`)))
+			tasks = append(tasks, &scheduler.Task{
+				QueuePath:   queuePath,
+				Prefix:      "test_",
+				ID:          "id",
+				ScheduledAt: time.Unix(int64(i), 0).UTC(),
+				Request:     req,
+			})
+		}
+		return tasks
+	}
+	taskIDs := func() []string {
+		var ids []string
+		for _, t := range cli.Server.Tasks(queuePath) {
+			ids = append(ids, path.Base(t.Name))
+		}
+		return ids
+	}
+
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+	assert.NoError(t, s.Sync(ctx, newTasks("https://example.com/")))
+	assert.Equal(t, []string{"test_id_0v1", "test_id_3b9aca00v1", "test_id_77359400v1"}, taskIDs())
+
+	assert.NoError(t, s.Sync(ctx, newTasks("https://example.com/")))
+	assert.Equal(t, []string{"test_id_0v1", "test_id_3b9aca00v1", "test_id_77359400v1"}, taskIDs())
+
+	assert.NoError(t, s.Sync(ctx, newTasks("https://example.com/v2")[1:]))
+	assert.Equal(t, []string{"test_id_3b9aca00v2", "test_id_77359400v2"}, taskIDs())
+}
+
+func TestScheduler_Plan(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	queuePath := "projects/tokyo-rain-123/locations/asia-northeast1/queues/scheduler"
+	remoteTasks := []*taskspb.Task{
+		{
+			Name:         queuePath + "/tasks/test_keep_3b9aca02v1",
+			ScheduleTime: &timestamppb.Timestamp{Seconds: 1, Nanos: 2},
+			MessageType: &taskspb.Task_HttpRequest{
+				HttpRequest: &taskspb.HttpRequest{
+					Url:        "https://example.com/",
+					HttpMethod: taskspb.HttpMethod_GET,
+				},
+			},
+		},
+		{
+			Name:         queuePath + "/tasks/test_update_3b9aca02v2",
+			ScheduleTime: &timestamppb.Timestamp{Seconds: 1, Nanos: 2},
+			MessageType: &taskspb.Task_HttpRequest{
+				HttpRequest: &taskspb.HttpRequest{
+					Url:        "https://example.com/old",
+					HttpMethod: taskspb.HttpMethod_GET,
+				},
+			},
+		},
+		{
+			Name:         queuePath + "/tasks/test_delete_3b9aca02v1",
+			ScheduleTime: &timestamppb.Timestamp{Seconds: 1, Nanos: 2},
+			MessageType: &taskspb.Task_HttpRequest{
+				HttpRequest: &taskspb.HttpRequest{
+					Url:        "https://example.com/",
+					HttpMethod: taskspb.HttpMethod_GET,
+				},
+			},
+		},
+	}
+	newTask := func(id, url string) *scheduler.Task {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		return &scheduler.Task{
+			QueuePath:   queuePath,
+			Prefix:      "test_",
+			ID:          id,
+			ScheduledAt: time.Unix(1, 2).UTC(),
+			Request:     req,
+			Version:     1,
+		}
+	}
+	keep := newTask("keep", "https://example.com/")
+	update := newTask("update", "https://example.com/new")
+	create := newTask("create", "https://example.com/")
+
+	ctrl := gomock.NewController(t)
+	m := mock_scheduler.NewMockCloudTasksClient(ctrl)
+	l := mock_scheduler.NewMockTaskLister(ctrl)
+	i := mock_scheduler.NewMockTaskIterator(ctrl)
+	l.EXPECT().ListTasks(ctx, gomock.Any()).Return(i)
+	i.EXPECT().PageInfo().Return(&iterator.PageInfo{}).AnyTimes()
+	for _, rt := range remoteTasks {
+		i.EXPECT().Next().Return(rt, nil)
+	}
+	i.EXPECT().Next().Return(nil, scheduler.Done)
+
+	s := scheduler.New(m, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+	s.SetIterator(func(opts ...gax.CallOption) *scheduler.Iterator {
+		return scheduler.NewIterator(l, queuePath, "test_", opts...)
+	})
+
+	plan, err := s.Plan(ctx, []*scheduler.Task{keep, update, create})
+	assert.NoError(t, err)
+	assert.False(t, plan.Empty())
+
+	taskIDs := func(tasks []*scheduler.Task) []string {
+		ids := make([]string, 0, len(tasks))
+		for _, t := range tasks {
+			ids = append(ids, t.TaskID())
+		}
+		return ids
+	}
+	assert.Equal(t, []string{"test_keep_3b9aca02v1"}, taskIDs(plan.Unchanged))
+	assert.Equal(t, []string{"test_update_3b9aca02v2", "test_delete_3b9aca02v1"}, taskIDs(plan.Delete))
+	assert.Equal(t, []string{"test_update_3b9aca02v3", "test_create_3b9aca02v1"}, taskIDs(plan.Create))
+}
+
 func TestScheduler_List(t *testing.T) {
 	t.Parallel()
 
@@ -563,3 +695,679 @@ func TestScheduler_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestScheduler_RunNow(t *testing.T) {
+	t.Parallel()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	tests := []struct {
+		name    string
+		run     func(ctx context.Context, s *scheduler.Scheduler) (string, error)
+		want    string
+		wantErr error
+	}{
+		{
+			name: "run latest version at the time",
+			run: func(ctx context.Context, s *scheduler.Scheduler) (string, error) {
+				return s.RunNow(ctx, "id", time.Unix(20, 0))
+			},
+			want: queuePath + "/tasks/test_id_4a817c800v2",
+		},
+		{
+			name: "run next occurrence",
+			run: func(ctx context.Context, s *scheduler.Scheduler) (string, error) {
+				return s.RunNext(ctx, "id")
+			},
+			want: queuePath + "/tasks/test_id_2540be400v1",
+		},
+		{
+			name: "unknown time",
+			run: func(ctx context.Context, s *scheduler.Scheduler) (string, error) {
+				return s.RunNow(ctx, "id", time.Unix(30, 0))
+			},
+			wantErr: scheduler.ErrTaskNotFound,
+		},
+		{
+			name: "unknown id",
+			run: func(ctx context.Context, s *scheduler.Scheduler) (string, error) {
+				return s.RunNext(ctx, "other")
+			},
+			wantErr: scheduler.ErrTaskNotFound,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			cli, err := schedulertest.NewFakeClient(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cli.Close()
+			for name, at := range map[string]int64{"test_id_4a817c800v1": 20, "test_id_4a817c800v2": 20, "test_id_2540be400v1": 10} {
+				cli.Server.AddTask(&taskspb.Task{
+					Name:         queuePath + "/tasks/" + name,
+					ScheduleTime: &timestamppb.Timestamp{Seconds: at},
+					MessageType:  &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: "https://example.com/", HttpMethod: taskspb.HttpMethod_POST}},
+				})
+			}
+
+			s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+			got, err := tt.run(ctx, s)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got: %v, want: %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+			for _, task := range cli.Server.Tasks(queuePath) {
+				assert.NotEqual(t, tt.want, task.Name, "dispatched task is removed")
+			}
+		})
+	}
+}
+
+func TestScheduler_Get(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	for name, at := range map[string]int64{"test_id_4a817c800v1": 20, "test_id_4a817c800v2": 20, "test_id_2540be400v1": 10, "test_other_2540be400v1": 10} {
+		cli.Server.AddTask(&taskspb.Task{
+			Name:         queuePath + "/tasks/" + name,
+			ScheduleTime: &timestamppb.Timestamp{Seconds: at},
+			MessageType: &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{
+				Url:        "https://example.com/",
+				HttpMethod: taskspb.HttpMethod_POST,
+				Body:       []byte(name),
+			}},
+		})
+	}
+
+	registry := scheduler.NewVersionRegistry()
+	tests := []struct {
+		name        string
+		s           *scheduler.Scheduler
+		scheduledAt time.Time
+		want        string
+		wantErr     error
+	}{
+		{
+			name:        "latest version at the time",
+			s:           scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_"),
+			scheduledAt: time.Unix(20, 0),
+			want:        "test_id_4a817c800v2",
+		},
+		{
+			name:        "version from registry",
+			s:           scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_", scheduler.WithVersionRegistry(registry)),
+			scheduledAt: time.Unix(10, 0),
+			want:        "test_id_2540be400v1",
+		},
+		{
+			name:        "unknown time",
+			s:           scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_"),
+			scheduledAt: time.Unix(30, 0),
+			wantErr:     scheduler.ErrTaskNotFound,
+		},
+	}
+	registry.Record(&scheduler.Task{QueuePath: queuePath, Prefix: "test_", ID: "id", ScheduledAt: time.Unix(10, 0), Version: 1})
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tt.s.Get(ctx, "id", tt.scheduledAt)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got: %v, want: %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			assert.Equal(t, queuePath+"/tasks/"+tt.want, got.TaskName())
+			body, err := io.ReadAll(got.Request.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(body), "body is included")
+		})
+	}
+}
+
+func TestScheduler_FindByID(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	for name, at := range map[string]int64{"test_id_4a817c800v2": 20, "test_id_4a817c800v1": 20, "test_id_2540be400v1": 10, "test_other_2540be400v1": 10, "other_id_2540be400v1": 10} {
+		cli.Server.AddTask(&taskspb.Task{
+			Name:         queuePath + "/tasks/" + name,
+			ScheduleTime: &timestamppb.Timestamp{Seconds: at},
+			MessageType:  &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: "https://example.com/", HttpMethod: taskspb.HttpMethod_POST}},
+		})
+	}
+
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+	got, err := s.FindByID(ctx, "id")
+	require.NoError(t, err)
+	var names []string
+	for _, task := range got {
+		names = append(names, path.Base(task.TaskName()))
+	}
+	assert.Equal(t, []string{"test_id_2540be400v1", "test_id_4a817c800v1", "test_id_4a817c800v2"}, names)
+
+	got, err = s.FindByID(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestScheduler_Move(t *testing.T) {
+	t.Parallel()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	tests := []struct {
+		name      string
+		tasks     map[string]int64
+		bodies    map[string]string
+		to        int64
+		dispatch  bool
+		fault     schedulertest.FaultFunc
+		want      string
+		wantTasks []string
+		wantErr   error
+	}{
+		{
+			name:      "move",
+			tasks:     map[string]int64{"test_id_2540be400v2": 10},
+			want:      "test_id_4a817c800v2",
+			wantTasks: []string{"test_id_4a817c800v2"},
+		},
+		{
+			name:      "retry after creation",
+			tasks:     map[string]int64{"test_id_2540be400v1": 10, "test_id_4a817c800v1": 20},
+			want:      "test_id_4a817c800v1",
+			wantTasks: []string{"test_id_4a817c800v1"},
+		},
+		{
+			name:      "retry after creation of an older version",
+			tasks:     map[string]int64{"test_id_2540be400v2": 10, "test_id_4a817c800v1": 20},
+			want:      "test_id_4a817c800v1",
+			wantTasks: []string{"test_id_4a817c800v1"},
+		},
+		{
+			name:      "conflict with a different task",
+			tasks:     map[string]int64{"test_id_2540be400v1": 10, "test_id_4a817c800v1": 20},
+			bodies:    map[string]string{"test_id_4a817c800v1": "other"},
+			wantTasks: []string{"test_id_2540be400v1", "test_id_4a817c800v1"},
+			wantErr:   scheduler.ErrTaskConflict,
+		},
+		{
+			name:      "retry after deletion",
+			tasks:     map[string]int64{"test_id_4a817c800v1": 20},
+			want:      "test_id_4a817c800v1",
+			wantTasks: []string{"test_id_4a817c800v1"},
+		},
+		{
+			name:      "source not found",
+			tasks:     map[string]int64{"test_id_3b9aca00v1": 1},
+			wantTasks: []string{"test_id_3b9aca00v1"},
+			wantErr:   scheduler.ErrTaskNotFound,
+		},
+		{
+			name:      "source dispatched",
+			tasks:     map[string]int64{"test_id_2540be400v1": 10},
+			dispatch:  true,
+			wantTasks: []string{"test_id_2540be400v1"},
+			wantErr:   scheduler.ErrTaskDispatched,
+		},
+		{
+			name:      "same time",
+			tasks:     map[string]int64{"test_id_2540be400v1": 10},
+			to:        10,
+			wantTasks: []string{"test_id_2540be400v1"},
+			wantErr:   scheduler.ErrTaskValidation,
+		},
+		{
+			name:      "no free name",
+			tasks:     map[string]int64{"test_id_2540be400v1": 10},
+			fault:     schedulertest.FailMethod("CreateTask", codes.AlreadyExists, -1),
+			wantTasks: []string{"test_id_2540be400v1"},
+			wantErr:   scheduler.ErrTaskAlreadyExists,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			cli, err := schedulertest.NewFakeClient(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cli.Close()
+			for name, at := range tt.tasks {
+				body := "body"
+				if b, ok := tt.bodies[name]; ok {
+					body = b
+				}
+				pb := &taskspb.Task{
+					Name:         queuePath + "/tasks/" + name,
+					ScheduleTime: &timestamppb.Timestamp{Seconds: at},
+					MessageType: &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{
+						Url:        "https://example.com/",
+						HttpMethod: taskspb.HttpMethod_POST,
+						Body:       []byte(body),
+					}},
+				}
+				if tt.dispatch {
+					pb.DispatchCount = 1
+				}
+				cli.Server.AddTask(pb)
+			}
+
+			if tt.fault != nil {
+				cli.Server.SetFault(tt.fault)
+			}
+			to := tt.to
+			if to == 0 {
+				to = 20
+			}
+
+			s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+			got, err := s.Move(ctx, "id", time.Unix(10, 0), time.Unix(to, 0))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got: %v, want: %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, task := range cli.Server.Tasks(queuePath) {
+				names = append(names, path.Base(task.Name))
+			}
+			assert.Equal(t, tt.wantTasks, names)
+			if tt.wantErr != nil {
+				return
+			}
+			assert.Equal(t, queuePath+"/tasks/"+tt.want, got.TaskName())
+			assert.True(t, got.ScheduledAt.Equal(time.Unix(20, 0)))
+			assert.Equal(t, "body", string(cli.Server.Tasks(queuePath)[0].GetHttpRequest().Body), "body is kept")
+		})
+	}
+}
+
+func TestIterator_Filter(t *testing.T) {
+	t.Parallel()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	newPbTask := func(name string, at int64, auth bool) *taskspb.Task {
+		req := &taskspb.HttpRequest{Url: "https://example.com/", HttpMethod: taskspb.HttpMethod_POST}
+		if auth {
+			req.AuthorizationHeader = &taskspb.HttpRequest_OidcToken{OidcToken: &taskspb.OidcToken{ServiceAccountEmail: "sa@example.com"}}
+		}
+		return &taskspb.Task{
+			Name:         queuePath + "/tasks/" + name,
+			ScheduleTime: &timestamppb.Timestamp{Seconds: at},
+			MessageType:  &taskspb.Task_HttpRequest{HttpRequest: req},
+		}
+	}
+	// ordered by schedule time
+	pbTasks := []*taskspb.Task{
+		newPbTask("test_a_2540be400v1", 10, false),
+		newPbTask("test_b_2540be400v2", 10, true),
+		newPbTask("test_a_4a817c800v1", 20, true),
+		newPbTask("test_b_6fc23ac00v1", 30, false),
+	}
+
+	tests := []struct {
+		name   string
+		filter func(i *scheduler.Iterator) *scheduler.Iterator
+		listed int
+		want   []string
+	}{
+		{
+			name: "id",
+			filter: func(i *scheduler.Iterator) *scheduler.Iterator {
+				return i.WithIDFilter(func(id string) bool { return id == "a" })
+			},
+			listed: 4,
+			want:   []string{"test_a_2540be400v1", "test_a_4a817c800v1"},
+		},
+		{
+			name: "scheduled between",
+			filter: func(i *scheduler.Iterator) *scheduler.Iterator {
+				return i.WithScheduledBetween(time.Unix(20, 0), time.Time{})
+			},
+			listed: 4,
+			want:   []string{"test_a_4a817c800v1", "test_b_6fc23ac00v1"},
+		},
+		{
+			name: "authorization type",
+			filter: func(i *scheduler.Iterator) *scheduler.Iterator {
+				return i.WithAuthorizationType(scheduler.AuthorizationOIDC)
+			},
+			listed: 4,
+			want:   []string{"test_b_2540be400v2", "test_a_4a817c800v1"},
+		},
+		{
+			name: "combined filters",
+			filter: func(i *scheduler.Iterator) *scheduler.Iterator {
+				return i.WithAuthorizationType(scheduler.AuthorizationNone).WithFilter(func(t *scheduler.Task) bool { return t.Version == 1 })
+			},
+			listed: 4,
+			want:   []string{"test_a_2540be400v1", "test_b_6fc23ac00v1"},
+		},
+		{
+			name: "stop early with schedule time order",
+			filter: func(i *scheduler.Iterator) *scheduler.Iterator {
+				return i.WithScheduledBetween(time.Time{}, time.Unix(20, 0)).WithScheduleTimeOrder()
+			},
+			listed: 3,
+			want:   []string{"test_a_2540be400v1", "test_b_2540be400v2"},
+		},
+		{
+			name: "intersection of ranges with schedule time order",
+			filter: func(i *scheduler.Iterator) *scheduler.Iterator {
+				return i.WithScheduledBetween(time.Time{}, time.Unix(20, 0)).
+					WithScheduledBetween(time.Unix(10, 0), time.Unix(30, 0)).
+					WithScheduledBetween(time.Unix(10, 0), time.Time{}).
+					WithScheduleTimeOrder()
+			},
+			listed: 3,
+			want:   []string{"test_a_2540be400v1", "test_b_2540be400v2"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			ctrl := gomock.NewController(t)
+			l := mock_scheduler.NewMockTaskLister(ctrl)
+			i := mock_scheduler.NewMockTaskIterator(ctrl)
+			l.EXPECT().ListTasks(ctx, gomock.Any()).Return(i)
+			i.EXPECT().PageInfo().Return(&iterator.PageInfo{}).AnyTimes()
+			for _, pb := range pbTasks[:tt.listed] {
+				i.EXPECT().Next().Return(pb, nil)
+			}
+			if tt.listed == len(pbTasks) {
+				i.EXPECT().Next().Return(nil, scheduler.Done)
+			}
+
+			iter := tt.filter(scheduler.NewIterator(l, queuePath, "test_"))
+			var got []string
+			for {
+				task, err := iter.Next(ctx)
+				if errors.Is(err, scheduler.Done) {
+					break
+				}
+				require.NoError(t, err)
+				got = append(got, path.Base(task.TaskName()))
+			}
+			assert.Equal(t, tt.want, got)
+			if tt.listed < len(pbTasks) {
+				_, err := iter.Next(ctx)
+				assert.ErrorIs(t, err, scheduler.Done, "stopped iterator stays done")
+			}
+		})
+	}
+}
+
+func TestScheduler_All(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	for _, name := range []string{"test_a_3b9aca00v1", "test_b_3b9aca00v1", "test_c_3b9aca00v1", "other_a_3b9aca00v1"} {
+		cli.Server.AddTask(&taskspb.Task{
+			Name:         queuePath + "/tasks/" + name,
+			ScheduleTime: &timestamppb.Timestamp{Seconds: 1},
+			MessageType:  &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: "https://example.com/", HttpMethod: taskspb.HttpMethod_POST}},
+		})
+	}
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+
+	var ids []string
+	for task, err := range s.All(ctx) {
+		require.NoError(t, err)
+		ids = append(ids, task.ID)
+		if len(ids) == 2 {
+			break
+		}
+	}
+	assert.Equal(t, []string{"a", "b"}, ids)
+
+	var pages [][]*scheduler.Task
+	for page, err := range s.Pages(ctx) {
+		require.NoError(t, err)
+		pages = append(pages, page)
+	}
+	require.Len(t, pages, 1)
+	assert.Len(t, pages[0], 3)
+
+	tasks, err := scheduler.Collect(s.All(ctx), 3)
+	require.NoError(t, err)
+	assert.Len(t, tasks, 3)
+
+	_, err = scheduler.Collect(s.All(ctx), 2)
+	assert.ErrorIs(t, err, scheduler.ErrTooManyTasks)
+
+	tasks, err = scheduler.Collect(s.List().WithIDFilter(func(id string) bool { return id == "c" }).All(ctx), 0)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "c", tasks[0].ID)
+
+	cli.Server.SetFault(schedulertest.FailMethod("ListTasks", codes.PermissionDenied, 1))
+	_, err = scheduler.Collect(s.All(ctx), 0)
+	assert.Equal(t, codes.PermissionDenied, status.Code(errors.Unwrap(err)))
+}
+
+func TestScheduler_ListPage(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		cli.Server.AddTask(&taskspb.Task{
+			Name:         queuePath + "/tasks/test_" + id + "_3b9aca00v1",
+			ScheduleTime: &timestamppb.Timestamp{Seconds: 1},
+			MessageType:  &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: "https://example.com/", HttpMethod: taskspb.HttpMethod_POST}},
+		})
+	}
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+
+	ids := func(tasks []*scheduler.Task) []string {
+		ids := make([]string, 0, len(tasks))
+		for _, t := range tasks {
+			ids = append(ids, t.ID)
+		}
+		return ids
+	}
+
+	var pages [][]string
+	token := ""
+	for {
+		tasks, next, err := s.ListPage(ctx, token, 2)
+		require.NoError(t, err)
+		pages = append(pages, ids(tasks))
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, pages)
+
+	it := s.List().WithPageSize(2)
+	for range 3 {
+		_, err := it.Next(ctx)
+		require.NoError(t, err)
+	}
+	token, ok := it.PageToken()
+	require.True(t, ok)
+	tasks, err := scheduler.Collect(s.ListFrom(token).WithPageSize(2).All(ctx), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "d", "e"}, ids(tasks), "resumes from the page of the last task")
+
+	for {
+		if _, err := it.Next(ctx); errors.Is(err, scheduler.Done) {
+			break
+		}
+	}
+	_, ok = it.PageToken()
+	assert.False(t, ok, "nothing to resume after Done")
+	token, ok = s.List().PageToken()
+	assert.True(t, ok)
+	assert.Empty(t, token, "the first page")
+
+	tasks, err = scheduler.Collect(s.List().WithPageSize(5000).All(ctx), 0)
+	require.NoError(t, err, "page sizes over the maximum are clamped")
+	assert.Len(t, tasks, 5)
+
+	tasks, _, err = s.List().WithPageSize(2).WithIDFilter(func(id string) bool { return id == "d" }).NextPage(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, tasks, "unmatched pages are empty")
+}
+
+func TestIterator_NextPage_zeroPageInfo(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	ctrl := gomock.NewController(t)
+	l := mock_scheduler.NewMockTaskLister(ctrl)
+	i := mock_scheduler.NewMockTaskIterator(ctrl)
+	l.EXPECT().ListTasks(ctx, gomock.Any()).Return(i)
+	i.EXPECT().PageInfo().Return(&iterator.PageInfo{})
+	for _, name := range []string{"test_a_3b9aca00v1", "test_b_3b9aca00v1"} {
+		i.EXPECT().Next().Return(&taskspb.Task{
+			Name:         queuePath + "/tasks/" + name,
+			ScheduleTime: &timestamppb.Timestamp{Seconds: 1},
+			MessageType:  &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: "https://example.com/", HttpMethod: taskspb.HttpMethod_POST}},
+		}, nil)
+	}
+	i.EXPECT().Next().Return(nil, iterator.Done)
+
+	tasks, next, err := scheduler.NewIterator(l, queuePath, "test_").NextPage(ctx)
+	require.NoError(t, err)
+	assert.Len(t, tasks, 2, "a list without page info is a page")
+	assert.Empty(t, next)
+}
+
+func TestScheduler_MalformedTasks(t *testing.T) {
+	t.Parallel()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	addTasks := func(cli *schedulertest.FakeClient) {
+		http := &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: "https://example.com/", HttpMethod: taskspb.HttpMethod_POST}}
+		for _, pb := range []*taskspb.Task{
+			{Name: queuePath + "/tasks/test_a_3b9aca00v1", ScheduleTime: &timestamppb.Timestamp{Seconds: 1}, MessageType: http},
+			{Name: queuePath + "/tasks/test_broken", ScheduleTime: &timestamppb.Timestamp{Seconds: 1}, MessageType: http},
+			{Name: queuePath + "/tasks/test_b_3b9aca00v1", ScheduleTime: &timestamppb.Timestamp{Seconds: 1}, MessageType: &taskspb.Task_AppEngineHttpRequest{AppEngineHttpRequest: &taskspb.AppEngineHttpRequest{RelativeUri: "/"}}},
+			{Name: queuePath + "/tasks/other_broken", ScheduleTime: &timestamppb.Timestamp{Seconds: 1}, MessageType: http},
+		} {
+			cli.Server.AddTask(pb)
+		}
+	}
+
+	var reported []string
+	tests := []struct {
+		name     string
+		policy   scheduler.MalformedTaskPolicy
+		want     []string
+		reported []string
+		wantErr  bool
+	}{
+		{
+			name:    "fail by default",
+			wantErr: true,
+		},
+		{
+			name:   "skip",
+			policy: scheduler.SkipMalformedTask,
+			want:   []string{"a"},
+		},
+		{
+			name: "skip and report",
+			policy: scheduler.ReportMalformedTask(func(name string, _ error) {
+				reported = append(reported, path.Base(name))
+			}),
+			want:     []string{"a"},
+			reported: []string{"test_b_3b9aca00v1", "test_broken"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli, err := schedulertest.NewFakeClient(ctx)
+			require.NoError(t, err)
+			defer cli.Close()
+			addTasks(cli)
+
+			var opts []scheduler.Option
+			if tt.policy != nil {
+				opts = append(opts, scheduler.WithMalformedTaskPolicy(tt.policy))
+			}
+			s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_", opts...)
+			tasks, err := scheduler.Collect(s.All(ctx), 0)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var ids []string
+			for _, task := range tasks {
+				ids = append(ids, task.ID)
+			}
+			assert.Equal(t, tt.want, ids)
+			assert.Equal(t, tt.reported, reported)
+		})
+	}
+
+	t.Run("sync deletes foreign tasks", func(t *testing.T) {
+		ctx := context.Background()
+		cli, err := schedulertest.NewFakeClient(ctx)
+		require.NoError(t, err)
+		defer cli.Close()
+		addTasks(cli)
+
+		s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_", scheduler.WithForeignTaskCleanup())
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://example.com/", nil)
+		require.NoError(t, err)
+		task := &scheduler.Task{QueuePath: queuePath, Prefix: "test_", ID: "a", ScheduledAt: time.Unix(1, 0), Request: req, Version: 1}
+
+		plan, err := s.Plan(ctx, []*scheduler.Task{task})
+		require.NoError(t, err)
+		assert.Len(t, plan.Unchanged, 1)
+		assert.Equal(t, []string{queuePath + "/tasks/test_b_3b9aca00v1", queuePath + "/tasks/test_broken"}, plan.Foreign)
+		assert.False(t, plan.Empty())
+
+		require.NoError(t, s.Sync(ctx, []*scheduler.Task{task}))
+		var names []string
+		for _, pb := range cli.Server.Tasks(queuePath) {
+			names = append(names, path.Base(pb.Name))
+		}
+		assert.Equal(t, []string{"other_broken", "test_a_3b9aca00v1"}, names, "tasks of other prefixes are kept")
+	})
+}
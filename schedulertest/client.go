@@ -0,0 +1,75 @@
+package schedulertest
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	"google.golang.org/api/option"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/go-oss/scheduler"
+)
+
+const bufferSize = 1 << 20
+
+// FakeClient is a *cloudtasks.Client connected to an in-process Server.
+// It implements scheduler.CloudTasksClient with the semantics of Cloud Tasks:
+// paginated listing, AlreadyExists and NotFound errors, and tombstoned names of deleted tasks.
+//
+// Note that the client retries ListTasks, GetTask and DeleteTask on Unavailable and DeadlineExceeded
+// as the real client does, so faults injected with these codes are retried.
+type FakeClient struct {
+	*cloudtasks.Client
+	Server *Server
+
+	grpcServer *grpc.Server
+}
+
+var _ scheduler.CloudTasksClient = (*FakeClient)(nil)
+
+func NewFakeClient(ctx context.Context, opts ...ServerOption) (*FakeClient, error) {
+	return newFakeClient(ctx, NewServer(opts...))
+}
+
+func newFakeClient(ctx context.Context, srv *Server) (*FakeClient, error) {
+	lis := bufconn.Listen(bufferSize)
+	gs := grpc.NewServer(grpc.UnaryInterceptor(srv.UnaryInterceptor))
+	taskspb.RegisterCloudTasksServer(gs, srv)
+	go gs.Serve(lis)
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		gs.Stop()
+		return nil, fmt.Errorf("failed to dial fake server: %w", err)
+	}
+
+	cli, err := cloudtasks.NewClient(ctx, option.WithGRPCConn(conn))
+	if err != nil {
+		conn.Close()
+		gs.Stop()
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	return &FakeClient{
+		Client:     cli,
+		Server:     srv,
+		grpcServer: gs,
+	}, nil
+}
+
+// Close closes the client and stops the server.
+func (c *FakeClient) Close() error {
+	err := c.Client.Close()
+	c.grpcServer.Stop()
+	return err
+}
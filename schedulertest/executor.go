@@ -0,0 +1,432 @@
+package schedulertest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultPollInterval     = time.Second
+	defaultDispatchDeadline = 10 * time.Minute
+	userAgent               = "Google-Cloud-Tasks"
+)
+
+// RetryConfig controls retries of failed dispatches like the retry config of a Cloud Tasks queue.
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts including the first one. Zero or less means unlimited.
+	MaxAttempts  int
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	MaxDoublings int
+}
+
+// DefaultRetryConfig is the default retry config of Cloud Tasks queues.
+var DefaultRetryConfig = RetryConfig{
+	MaxAttempts:  100,
+	MinBackoff:   100 * time.Millisecond,
+	MaxBackoff:   time.Hour,
+	MaxDoublings: 16,
+}
+
+func (c *RetryConfig) backoff(retry int) time.Duration {
+	doublings := retry
+	if doublings > c.MaxDoublings {
+		doublings = c.MaxDoublings
+	}
+
+	// doubling is stopped at MaxBackoff, so it never overflows
+	d := c.MinBackoff
+	for i := 0; i < doublings && d < c.MaxBackoff; i++ {
+		if d > c.MaxBackoff/2 {
+			d = c.MaxBackoff
+			break
+		}
+		d *= 2
+	}
+	// after MaxDoublings the backoff grows linearly by the last interval
+	if n := time.Duration(retry - c.MaxDoublings); n > 0 && d < c.MaxBackoff {
+		if d > (c.MaxBackoff-d)/n {
+			d = c.MaxBackoff
+		} else {
+			d += n * d
+		}
+	}
+	if d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+
+	return d
+}
+
+// TokenFunc returns the bearer token sent with a task which has an authorization header.
+type TokenFunc func(ctx context.Context, req *taskspb.HttpRequest) (string, error)
+
+// PlaceholderToken returns fixed tokens which tell the kind of the authorization header and the service account.
+// They are not signed and must not be accepted by production receivers.
+func PlaceholderToken(_ context.Context, req *taskspb.HttpRequest) (string, error) {
+	switch {
+	case req.GetOidcToken() != nil:
+		return "local-oidc-token:" + req.GetOidcToken().ServiceAccountEmail, nil
+	case req.GetOauthToken() != nil:
+		return "local-oauth-token:" + req.GetOauthToken().ServiceAccountEmail, nil
+	}
+
+	return "", nil
+}
+
+type ExecutorOption func(*LocalExecutor)
+
+// WithExecutorClock sets the clock which decides when tasks are due. It is also used by the server.
+func WithExecutorClock(c Clock) ExecutorOption {
+	return func(e *LocalExecutor) {
+		e.clock = c
+	}
+}
+
+func WithHTTPClient(c *http.Client) ExecutorOption {
+	return func(e *LocalExecutor) {
+		e.httpClient = c
+	}
+}
+
+// WithRetryConfig overrides the retry configs of all queues.
+func WithRetryConfig(c RetryConfig) ExecutorOption {
+	return func(e *LocalExecutor) {
+		e.retry = &c
+	}
+}
+
+func WithTokenFunc(f TokenFunc) ExecutorOption {
+	return func(e *LocalExecutor) {
+		e.token = f
+	}
+}
+
+// WithPollInterval sets the maximum interval between checks for due tasks in Start.
+func WithPollInterval(d time.Duration) ExecutorOption {
+	return func(e *LocalExecutor) {
+		e.pollInterval = d
+	}
+}
+
+func WithServerOptions(opts ...ServerOption) ExecutorOption {
+	return func(e *LocalExecutor) {
+		e.serverOpts = append(e.serverOpts, opts...)
+	}
+}
+
+// LocalExecutor is a FakeClient which dispatches the HTTP requests of tasks when they are due,
+// so scheduled tasks can be run locally without Cloud Tasks.
+//
+// Requests carry the X-CloudTasks-* headers of Cloud Tasks, and tasks with an authorization header
+// are sent with a bearer token from the TokenFunc (PlaceholderToken by default).
+// Tasks are deleted after a 2xx response and retried with exponential backoff otherwise,
+// following the retry config of their queue. Tasks in paused queues are not dispatched.
+type LocalExecutor struct {
+	*FakeClient
+
+	clock        Clock
+	httpClient   *http.Client
+	retry        *RetryConfig
+	token        TokenFunc
+	pollInterval time.Duration
+	serverOpts   []ServerOption
+
+	wakeCh chan struct{}
+	// guarded by Server.mu
+	inflight     map[string]bool
+	lastResponse map[string]int
+}
+
+func NewLocalExecutor(ctx context.Context, opts ...ExecutorOption) (*LocalExecutor, error) {
+	e := &LocalExecutor{
+		clock:        realClock{},
+		httpClient:   http.DefaultClient,
+		token:        PlaceholderToken,
+		pollInterval: defaultPollInterval,
+		wakeCh:       make(chan struct{}, 1),
+		inflight:     make(map[string]bool),
+		lastResponse: make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	srv := NewServer(append([]ServerOption{WithClock(e.clock.Now)}, e.serverOpts...)...)
+	srv.dispatcher = e
+	c, err := newFakeClient(ctx, srv)
+	if err != nil {
+		return nil, err
+	}
+	e.FakeClient = c
+
+	return e, nil
+}
+
+func (e *LocalExecutor) wake() {
+	select {
+	case e.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// Start dispatches due tasks until ctx is done.
+// Dispatches run in the background, so a slow receiver doesn't hold back the other due tasks,
+// and Start waits for them before it returns.
+func (e *LocalExecutor) Start(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		e.startDue(ctx, &wg)
+
+		wait := e.pollInterval
+		if next, ok := e.nextScheduleTime(); ok {
+			if d := next.Sub(e.clock.Now()); d < wait {
+				wait = d
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.wakeCh:
+		case <-e.clock.After(wait):
+		}
+	}
+}
+
+// RunDue dispatches every task whose schedule time has come and waits for the responses.
+// It returns the number of dispatched tasks.
+func (e *LocalExecutor) RunDue(ctx context.Context) int {
+	var wg sync.WaitGroup
+	n := e.startDue(ctx, &wg)
+	wg.Wait()
+
+	return n
+}
+
+// startDue dispatches the due tasks which are not in flight without waiting for the responses.
+// The executor is woken when a dispatch finishes, since a failed task is scheduled again.
+func (e *LocalExecutor) startDue(ctx context.Context, wg *sync.WaitGroup) int {
+	tasks := e.takeDue()
+	for _, t := range tasks {
+		wg.Add(1)
+		go func(t *taskspb.Task) {
+			defer wg.Done()
+			code, err := e.dispatch(ctx, t)
+			e.finish(t, code, err)
+			e.wake()
+		}(t)
+	}
+
+	return len(tasks)
+}
+
+// takeDue marks the due tasks in flight and returns copies of them.
+func (e *LocalExecutor) takeDue() []*taskspb.Task {
+	s := e.Server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var due []*taskspb.Task
+	for _, q := range s.queues {
+		if q.pb.State != taskspb.Queue_RUNNING {
+			continue
+		}
+		for name, t := range q.tasks {
+			if e.inflight[name] || t.ScheduleTime.AsTime().After(now) {
+				continue
+			}
+			e.inflight[name] = true
+			due = append(due, view(t, taskspb.Task_FULL))
+		}
+	}
+
+	return due
+}
+
+func (e *LocalExecutor) nextScheduleTime() (time.Time, bool) {
+	s := e.Server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, q := range s.queues {
+		if q.pb.State != taskspb.Queue_RUNNING {
+			continue
+		}
+		for name, t := range q.tasks {
+			if e.inflight[name] {
+				continue
+			}
+			if st := t.ScheduleTime.AsTime(); next.IsZero() || st.Before(next) {
+				next = st
+			}
+		}
+	}
+
+	return next, !next.IsZero()
+}
+
+// dispatch sends the request of t and returns the response status code.
+func (e *LocalExecutor) dispatch(ctx context.Context, t *taskspb.Task) (int, error) {
+	hr := t.GetHttpRequest()
+	deadline := defaultDispatchDeadline
+	if t.DispatchDeadline != nil {
+		deadline = t.DispatchDeadline.AsDuration()
+	}
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
+	var body io.Reader
+	if len(hr.Body) > 0 {
+		body = bytes.NewReader(hr.Body)
+	}
+	method := hr.HttpMethod.String()
+	if hr.HttpMethod == taskspb.HttpMethod_HTTP_METHOD_UNSPECIFIED {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, hr.Url, body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build request: %w", err)
+	}
+
+	for k, v := range hr.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-CloudTasks-QueueName", path.Base(queuePathOf(t.Name)))
+	req.Header.Set("X-CloudTasks-TaskName", path.Base(t.Name))
+	req.Header.Set("X-CloudTasks-TaskRetryCount", strconv.Itoa(int(t.DispatchCount)))
+	req.Header.Set("X-CloudTasks-TaskExecutionCount", strconv.Itoa(int(t.ResponseCount)))
+	req.Header.Set("X-CloudTasks-TaskETA", formatETA(t.ScheduleTime.AsTime()))
+	if prev := e.previousResponse(t.Name); prev != 0 {
+		req.Header.Set("X-CloudTasks-TaskPreviousResponse", strconv.Itoa(prev))
+	}
+
+	if hr.AuthorizationHeader != nil {
+		token, err := e.token(ctx, hr)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, nil
+}
+
+// finish records the attempt of t, deleting it on success and rescheduling it with backoff on failure.
+func (e *LocalExecutor) finish(t *taskspb.Task, code int, dispatchErr error) {
+	s := e.Server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(e.inflight, t.Name)
+	stored, err := s.task(t.Name)
+	if err != nil {
+		// deleted while in flight
+		delete(e.lastResponse, t.Name)
+		return
+	}
+
+	now := s.now()
+	attempt := &taskspb.Attempt{
+		ScheduleTime: stored.ScheduleTime,
+		DispatchTime: timestamppb.New(now),
+	}
+	stored.DispatchCount++
+	if dispatchErr == nil {
+		stored.ResponseCount++
+		attempt.ResponseTime = timestamppb.New(now)
+		attempt.ResponseStatus = responseStatus(code)
+		e.lastResponse[t.Name] = code
+	} else {
+		attempt.ResponseStatus = &rpcstatus.Status{Code: int32(codes.Unavailable), Message: dispatchErr.Error()}
+	}
+	stored.FirstAttempt = firstAttempt(stored.FirstAttempt, attempt.DispatchTime)
+	stored.LastAttempt = attempt
+
+	retry := e.retryConfig(s.queue(queuePathOf(t.Name)).pb)
+	if (dispatchErr == nil && code >= 200 && code < 300) ||
+		(retry.MaxAttempts > 0 && int(stored.DispatchCount) >= retry.MaxAttempts) {
+		delete(e.lastResponse, t.Name)
+		s.removeTask(t.Name)
+		return
+	}
+
+	stored.ScheduleTime = timestamppb.New(now.Add(retry.backoff(int(stored.DispatchCount) - 1)))
+}
+
+func (e *LocalExecutor) retryConfig(q *taskspb.Queue) RetryConfig {
+	if e.retry != nil {
+		return *e.retry
+	}
+
+	rc := q.GetRetryConfig()
+	return RetryConfig{
+		MaxAttempts:  int(rc.GetMaxAttempts()),
+		MinBackoff:   rc.GetMinBackoff().AsDuration(),
+		MaxBackoff:   rc.GetMaxBackoff().AsDuration(),
+		MaxDoublings: int(rc.GetMaxDoublings()),
+	}
+}
+
+func (e *LocalExecutor) previousResponse(name string) int {
+	e.Server.mu.Lock()
+	defer e.Server.mu.Unlock()
+	return e.lastResponse[name]
+}
+
+// responseStatus maps an HTTP status code to the status Cloud Tasks records for an attempt.
+func responseStatus(code int) *rpcstatus.Status {
+	c := codes.Unknown
+	switch {
+	case code >= 200 && code < 300:
+		c = codes.OK
+	case code == http.StatusBadRequest:
+		c = codes.InvalidArgument
+	case code == http.StatusUnauthorized:
+		c = codes.Unauthenticated
+	case code == http.StatusForbidden:
+		c = codes.PermissionDenied
+	case code == http.StatusNotFound:
+		c = codes.NotFound
+	case code == http.StatusConflict:
+		c = codes.Aborted
+	case code == http.StatusTooManyRequests:
+		c = codes.ResourceExhausted
+	case code == http.StatusNotImplemented:
+		c = codes.Unimplemented
+	case code == http.StatusServiceUnavailable:
+		c = codes.Unavailable
+	case code == http.StatusGatewayTimeout:
+		c = codes.DeadlineExceeded
+	}
+
+	return &rpcstatus.Status{Code: int32(c), Message: http.StatusText(code)}
+}
+
+func formatETA(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/float64(time.Second), 'f', 6, 64)
+}
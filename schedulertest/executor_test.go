@@ -0,0 +1,294 @@
+package schedulertest_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+type recorder struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	body, _ := io.ReadAll(req.Body)
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, string(body))
+
+	code := http.StatusOK
+	if len(r.statuses) > 0 {
+		code, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(code)
+}
+
+func TestLocalExecutor_RunDue(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	rec := &recorder{statuses: []int{http.StatusInternalServerError, http.StatusServiceUnavailable}}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	start := time.Unix(1000, 0).UTC()
+	clock := schedulertest.NewFakeClock(start)
+	e, err := schedulertest.NewLocalExecutor(ctx,
+		schedulertest.WithExecutorClock(clock),
+		schedulertest.WithRetryConfig(schedulertest.RetryConfig{
+			MaxAttempts:  5,
+			MinBackoff:   time.Second,
+			MaxBackoff:   time.Minute,
+			MaxDoublings: 3,
+		}),
+	)
+	require.NoError(t, err)
+	defer e.Close()
+
+	_, err = e.CreateTask(ctx, &taskspb.CreateTaskRequest{
+		Parent: queuePath,
+		Task: &taskspb.Task{
+			Name:         queuePath + "/tasks/task",
+			ScheduleTime: timestamppb.New(start.Add(time.Minute)),
+			MessageType: &taskspb.Task_HttpRequest{
+				HttpRequest: &taskspb.HttpRequest{
+					Url:        ts.URL + "/run",
+					HttpMethod: taskspb.HttpMethod_PUT,
+					Headers:    map[string]string{"Content-Type": "application/json"},
+					Body:       []byte(`{"payload":"test"}`),
+					AuthorizationHeader: &taskspb.HttpRequest_OidcToken{
+						OidcToken: &taskspb.OidcToken{ServiceAccountEmail: "invoker@example.com"},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, e.RunDue(ctx), "not due yet")
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, 1, e.RunDue(ctx))
+	assert.Equal(t, 0, e.RunDue(ctx), "backing off")
+
+	clock.Advance(time.Second)
+	assert.Equal(t, 1, e.RunDue(ctx))
+	assert.Equal(t, 0, e.RunDue(ctx), "backoff is doubled")
+
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, 1, e.RunDue(ctx))
+	assert.Empty(t, e.Server.Tasks(queuePath), "deleted after success")
+
+	require.Len(t, rec.requests, 3)
+	first, last := rec.requests[0], rec.requests[2]
+	assert.Equal(t, http.MethodPut, first.Method)
+	assert.Equal(t, "/run", first.URL.Path)
+	assert.Equal(t, `{"payload":"test"}`, rec.bodies[0])
+	assert.Equal(t, "application/json", first.Header.Get("Content-Type"))
+	assert.Equal(t, "Bearer local-oidc-token:invoker@example.com", first.Header.Get("Authorization"))
+	assert.Equal(t, "scheduler", first.Header.Get("X-CloudTasks-QueueName"))
+	assert.Equal(t, "task", first.Header.Get("X-CloudTasks-TaskName"))
+	assert.Equal(t, "0", first.Header.Get("X-CloudTasks-TaskRetryCount"))
+	assert.Equal(t, "0", first.Header.Get("X-CloudTasks-TaskExecutionCount"))
+	assert.Equal(t, "1060.000000", first.Header.Get("X-CloudTasks-TaskETA"))
+	assert.Empty(t, first.Header.Get("X-CloudTasks-TaskPreviousResponse"))
+	assert.Equal(t, "2", last.Header.Get("X-CloudTasks-TaskRetryCount"))
+	assert.Equal(t, "2", last.Header.Get("X-CloudTasks-TaskExecutionCount"))
+	assert.Equal(t, "503", last.Header.Get("X-CloudTasks-TaskPreviousResponse"))
+}
+
+func TestLocalExecutor_maxAttempts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	rec := &recorder{statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError}}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+
+	clock := schedulertest.NewFakeClock(time.Unix(1000, 0))
+	e, err := schedulertest.NewLocalExecutor(ctx,
+		schedulertest.WithExecutorClock(clock),
+		schedulertest.WithRetryConfig(schedulertest.RetryConfig{MaxAttempts: 2, MinBackoff: time.Second, MaxBackoff: time.Second}),
+	)
+	require.NoError(t, err)
+	defer e.Close()
+
+	_, err = e.CreateTask(ctx, &taskspb.CreateTaskRequest{
+		Parent: queuePath,
+		Task: &taskspb.Task{
+			MessageType: &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: ts.URL}},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, e.RunDue(ctx))
+	clock.Advance(time.Second)
+	assert.Equal(t, 1, e.RunDue(ctx))
+	assert.Empty(t, e.Server.Tasks(queuePath), "dropped after max attempts")
+}
+
+func TestLocalExecutor_Start(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(done)
+	}))
+	defer ts.Close()
+
+	e, err := schedulertest.NewLocalExecutor(ctx, schedulertest.WithPollInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer e.Close()
+	go e.Start(ctx)
+
+	_, err = e.CreateTask(ctx, &taskspb.CreateTaskRequest{
+		Parent: queuePath,
+		Task: &taskspb.Task{
+			MessageType: &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: ts.URL}},
+		},
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task was not dispatched")
+	}
+}
+
+func TestLocalExecutor_Start_slowReceiver(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	done := make(chan struct{})
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(done)
+	}))
+	defer fast.Close()
+
+	e, err := schedulertest.NewLocalExecutor(ctx, schedulertest.WithPollInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer e.Close()
+	go e.Start(ctx)
+
+	for _, url := range []string{slow.URL, fast.URL} {
+		_, err = e.CreateTask(ctx, &taskspb.CreateTaskRequest{
+			Parent: queuePath,
+			Task: &taskspb.Task{
+				MessageType: &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: url}},
+			},
+		})
+		require.NoError(t, err)
+		time.Sleep(50 * time.Millisecond) // let the slow task be dispatched first
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task was not dispatched while another one was in flight")
+	}
+}
+
+func TestRetryConfig_backoff(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		config schedulertest.RetryConfig
+		retry  int
+		want   time.Duration
+	}{
+		{
+			name:   "doubled",
+			config: schedulertest.DefaultRetryConfig,
+			retry:  3,
+			want:   800 * time.Millisecond,
+		},
+		{
+			name:   "linear after max doublings",
+			config: schedulertest.RetryConfig{MinBackoff: time.Second, MaxBackoff: time.Hour, MaxDoublings: 2},
+			retry:  4,
+			want:   12 * time.Second,
+		},
+		{
+			name:   "large max doublings",
+			config: schedulertest.RetryConfig{MinBackoff: time.Second, MaxBackoff: time.Hour, MaxDoublings: 100},
+			retry:  200,
+			want:   time.Hour,
+		},
+		{
+			name:   "large retry count",
+			config: schedulertest.RetryConfig{MinBackoff: time.Second, MaxBackoff: 1<<63 - 1, MaxDoublings: 62},
+			retry:  1 << 40,
+			want:   1<<63 - 1,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, tt.config.Backoff(tt.retry))
+		})
+	}
+}
+
+func TestLocalExecutor_Start_wakeOnCreate(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(done)
+	}))
+	defer ts.Close()
+
+	e, err := schedulertest.NewLocalExecutor(ctx, schedulertest.WithPollInterval(time.Hour))
+	require.NoError(t, err)
+	defer e.Close()
+	go e.Start(ctx)
+	time.Sleep(10 * time.Millisecond) // let Start wait for the poll interval
+
+	_, err = e.CreateTask(ctx, &taskspb.CreateTaskRequest{
+		Parent: queuePath,
+		Task: &taskspb.Task{
+			MessageType: &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: ts.URL}},
+		},
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task due now was not dispatched until the next poll")
+	}
+}
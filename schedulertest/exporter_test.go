@@ -0,0 +1,7 @@
+package schedulertest
+
+import "time"
+
+func (c *RetryConfig) Backoff(retry int) time.Duration {
+	return c.backoff(retry)
+}
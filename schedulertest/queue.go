@@ -0,0 +1,287 @@
+package schedulertest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// defaultQueue returns a queue with the defaults Cloud Tasks applies to unspecified fields.
+func defaultQueue(name string) *taskspb.Queue {
+	return &taskspb.Queue{
+		Name: name,
+		RateLimits: &taskspb.RateLimits{
+			MaxDispatchesPerSecond:  500,
+			MaxBurstSize:            100,
+			MaxConcurrentDispatches: 1000,
+		},
+		RetryConfig: &taskspb.RetryConfig{
+			MaxAttempts:  int32(DefaultRetryConfig.MaxAttempts),
+			MinBackoff:   durationpb.New(DefaultRetryConfig.MinBackoff),
+			MaxBackoff:   durationpb.New(DefaultRetryConfig.MaxBackoff),
+			MaxDoublings: int32(DefaultRetryConfig.MaxDoublings),
+		},
+		State: taskspb.Queue_RUNNING,
+	}
+}
+
+// mergeQueue copies the fields of src listed in paths into dst, or every mutable field if paths is empty.
+// Paths of sub-fields such as "retry_config.max_attempts" copy only the sub-field.
+// Unset fields are filled with the defaults.
+func mergeQueue(dst, src *taskspb.Queue, paths []string) error {
+	if len(paths) == 0 {
+		paths = []string{"app_engine_routing_override", "rate_limits", "retry_config", "stackdriver_logging_config"}
+	}
+
+	// dst must not share messages with the request, which the defaulting below mutates.
+	src = proto.Clone(src).(*taskspb.Queue)
+	def := defaultQueue(dst.Name)
+	for _, p := range paths {
+		fields := strings.Split(p, ".")
+		switch fields[0] {
+		case "app_engine_routing_override", "rate_limits", "retry_config", "stackdriver_logging_config":
+		default:
+			return status.Errorf(codes.InvalidArgument, "unsupported update mask path %q", p)
+		}
+		if err := mergeField(dst.ProtoReflect(), src.ProtoReflect(), fields); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid update mask path %q: %v", p, err)
+		}
+	}
+
+	if dst.RateLimits == nil {
+		dst.RateLimits = def.RateLimits
+	}
+	if dst.RateLimits.MaxDispatchesPerSecond == 0 {
+		dst.RateLimits.MaxDispatchesPerSecond = def.RateLimits.MaxDispatchesPerSecond
+	}
+	if dst.RateLimits.MaxConcurrentDispatches == 0 {
+		dst.RateLimits.MaxConcurrentDispatches = def.RateLimits.MaxConcurrentDispatches
+	}
+	// max_burst_size is output only
+	dst.RateLimits.MaxBurstSize = def.RateLimits.MaxBurstSize
+
+	if dst.RetryConfig == nil {
+		dst.RetryConfig = def.RetryConfig
+	}
+	if dst.RetryConfig.MaxAttempts == 0 {
+		dst.RetryConfig.MaxAttempts = def.RetryConfig.MaxAttempts
+	}
+	if dst.RetryConfig.MinBackoff == nil {
+		dst.RetryConfig.MinBackoff = def.RetryConfig.MinBackoff
+	}
+	if dst.RetryConfig.MaxBackoff == nil {
+		dst.RetryConfig.MaxBackoff = def.RetryConfig.MaxBackoff
+	}
+	if dst.RetryConfig.MaxDoublings == 0 {
+		dst.RetryConfig.MaxDoublings = def.RetryConfig.MaxDoublings
+	}
+
+	return nil
+}
+
+// mergeField copies the field of src at path into dst, clearing it if it is unset in src.
+func mergeField(dst, src protoreflect.Message, path []string) error {
+	fd := dst.Descriptor().Fields().ByName(protoreflect.Name(path[0]))
+	if fd == nil {
+		return fmt.Errorf("unknown field %s", path[0])
+	}
+	if len(path) == 1 {
+		if src.Has(fd) {
+			dst.Set(fd, src.Get(fd))
+		} else {
+			dst.Clear(fd)
+		}
+		return nil
+	}
+
+	if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+		return fmt.Errorf("field %s has no sub-fields", path[0])
+	}
+	return mergeField(dst.Mutable(fd).Message(), src.Get(fd).Message(), path[1:])
+}
+
+func (s *Server) ListQueues(_ context.Context, req *taskspb.ListQueuesRequest) (*taskspb.ListQueuesResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var queues []*taskspb.Queue
+	for name, q := range s.queues {
+		if strings.HasPrefix(name, req.Parent+"/queues/") {
+			queues = append(queues, proto.Clone(q.pb).(*taskspb.Queue))
+		}
+	}
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Name < queues[j].Name
+	})
+
+	start := 0
+	if req.PageToken != "" {
+		start = sort.Search(len(queues), func(i int) bool { return queues[i].Name > req.PageToken })
+	}
+	size := int(req.PageSize)
+	if size <= 0 || size > defaultPageSize {
+		size = defaultPageSize
+	}
+	end := start + size
+	if end > len(queues) {
+		end = len(queues)
+	}
+
+	resp := &taskspb.ListQueuesResponse{Queues: queues[start:end]}
+	if end < len(queues) {
+		resp.NextPageToken = queues[end-1].Name
+	}
+
+	return resp, nil
+}
+
+func (s *Server) GetQueue(_ context.Context, req *taskspb.GetQueueRequest) (*taskspb.Queue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.existingQueue(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Clone(q.pb).(*taskspb.Queue), nil
+}
+
+func (s *Server) CreateQueue(_ context.Context, req *taskspb.CreateQueueRequest) (*taskspb.Queue, error) {
+	if req.Queue == nil {
+		return nil, status.Error(codes.InvalidArgument, "queue is required")
+	}
+	if err := validateQueuePath(req.Queue.Name); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(req.Queue.Name, req.Parent+"/queues/") {
+		return nil, status.Errorf(codes.InvalidArgument, "queue %s is not in %s", req.Queue.Name, req.Parent)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.queues[req.Queue.Name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "queue %s already exists", req.Queue.Name)
+	}
+
+	q := s.newQueue(req.Queue.Name)
+	if err := mergeQueue(q.pb, req.Queue, nil); err != nil {
+		return nil, err
+	}
+	s.queues[req.Queue.Name] = q
+
+	return proto.Clone(q.pb).(*taskspb.Queue), nil
+}
+
+func (s *Server) UpdateQueue(_ context.Context, req *taskspb.UpdateQueueRequest) (*taskspb.Queue, error) {
+	if req.Queue == nil {
+		return nil, status.Error(codes.InvalidArgument, "queue is required")
+	}
+	if err := validateQueuePath(req.Queue.Name); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// UpdateQueue creates the queue if it does not exist.
+	q, ok := s.queues[req.Queue.Name]
+	if !ok {
+		q = s.newQueue(req.Queue.Name)
+	}
+	updated := proto.Clone(q.pb).(*taskspb.Queue)
+	if err := mergeQueue(updated, req.Queue, req.UpdateMask.GetPaths()); err != nil {
+		return nil, err
+	}
+	q.pb = updated
+	s.queues[req.Queue.Name] = q
+
+	return proto.Clone(q.pb).(*taskspb.Queue), nil
+}
+
+func (s *Server) DeleteQueue(_ context.Context, req *taskspb.DeleteQueueRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.existingQueue(req.Name); err != nil {
+		return nil, err
+	}
+	delete(s.queues, req.Name)
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) PurgeQueue(_ context.Context, req *taskspb.PurgeQueueRequest) (*taskspb.Queue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.existingQueue(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	for name := range q.tasks {
+		s.removeTask(name)
+	}
+	q.pb.PurgeTime = timestamppb.New(s.now())
+
+	return proto.Clone(q.pb).(*taskspb.Queue), nil
+}
+
+func (s *Server) PauseQueue(_ context.Context, req *taskspb.PauseQueueRequest) (*taskspb.Queue, error) {
+	return s.setQueueState(req.Name, taskspb.Queue_PAUSED)
+}
+
+func (s *Server) ResumeQueue(_ context.Context, req *taskspb.ResumeQueueRequest) (*taskspb.Queue, error) {
+	q, err := s.setQueueState(req.Name, taskspb.Queue_RUNNING)
+	if err == nil && s.dispatcher != nil {
+		s.dispatcher.wake()
+	}
+	return q, err
+}
+
+func (s *Server) setQueueState(name string, state taskspb.Queue_State) (*taskspb.Queue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.existingQueue(name)
+	if err != nil {
+		return nil, err
+	}
+	q.pb.State = state
+
+	return proto.Clone(q.pb).(*taskspb.Queue), nil
+}
+
+// existingQueue returns the queue of name without creating it. s.mu must be held.
+func (s *Server) existingQueue(name string) (*queue, error) {
+	if err := validateQueuePath(name); err != nil {
+		return nil, err
+	}
+
+	q, ok := s.queues[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "queue %s not found", name)
+	}
+
+	return q, nil
+}
+
+func (s *Server) newQueue(name string) *queue {
+	return &queue{
+		pb:         defaultQueue(name),
+		tasks:      make(map[string]*taskspb.Task),
+		tombstones: make(map[string]time.Time),
+	}
+}
@@ -0,0 +1,97 @@
+package schedulertest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/genproto/protobuf/field_mask"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+const locationPath = "projects/tokyo-rain-123/locations/asia-northeast1"
+
+func TestServer_queues(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := newFakeClient(t, schedulertest.WithStrictQueues())
+
+	_, err := c.CreateTask(ctx, &taskspb.CreateTaskRequest{Parent: queuePath, Task: newTask("task")})
+	assert.Equal(t, codes.NotFound, status.Code(err), "queues are not created implicitly")
+
+	q, err := c.CreateQueue(ctx, &taskspb.CreateQueueRequest{
+		Parent: locationPath,
+		Queue: &taskspb.Queue{
+			Name:       queuePath,
+			RateLimits: &taskspb.RateLimits{MaxDispatchesPerSecond: 10},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, q.RateLimits.MaxDispatchesPerSecond)
+	assert.Equal(t, int32(1000), q.RateLimits.MaxConcurrentDispatches, "defaults are filled")
+	assert.Equal(t, int32(100), q.RetryConfig.MaxAttempts)
+	assert.Equal(t, taskspb.Queue_RUNNING, q.State)
+
+	_, err = c.CreateQueue(ctx, &taskspb.CreateQueueRequest{Parent: locationPath, Queue: &taskspb.Queue{Name: queuePath}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	q, err = c.UpdateQueue(ctx, &taskspb.UpdateQueueRequest{
+		Queue: &taskspb.Queue{
+			Name:        queuePath,
+			RetryConfig: &taskspb.RetryConfig{MaxAttempts: 5, MinBackoff: durationpb.New(time.Second)},
+		},
+		UpdateMask: &field_mask.FieldMask{Paths: []string{"retry_config"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), q.RetryConfig.MaxAttempts)
+	assert.Equal(t, time.Second, q.RetryConfig.MinBackoff.AsDuration())
+	assert.Equal(t, 10.0, q.RateLimits.MaxDispatchesPerSecond, "fields out of the mask are kept")
+
+	req := &taskspb.UpdateQueueRequest{
+		Queue: &taskspb.Queue{
+			Name:        queuePath,
+			RetryConfig: &taskspb.RetryConfig{MaxAttempts: 7},
+		},
+		UpdateMask: &field_mask.FieldMask{Paths: []string{"retry_config.max_attempts"}},
+	}
+	q, err = c.Server.UpdateQueue(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), q.RetryConfig.MaxAttempts)
+	assert.Equal(t, time.Second, q.RetryConfig.MinBackoff.AsDuration(), "other sub-fields are kept")
+	assert.Nil(t, req.Queue.RetryConfig.MinBackoff, "the request is not mutated")
+
+	_, err = c.UpdateQueue(ctx, &taskspb.UpdateQueueRequest{
+		Queue:      &taskspb.Queue{Name: queuePath},
+		UpdateMask: &field_mask.FieldMask{Paths: []string{"retry_config.unknown"}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = c.CreateTask(ctx, &taskspb.CreateTaskRequest{Parent: queuePath, Task: newTask("task")})
+	require.NoError(t, err)
+
+	q, err = c.PauseQueue(ctx, &taskspb.PauseQueueRequest{Name: queuePath})
+	require.NoError(t, err)
+	assert.Equal(t, taskspb.Queue_PAUSED, q.State)
+
+	q, err = c.PurgeQueue(ctx, &taskspb.PurgeQueueRequest{Name: queuePath})
+	require.NoError(t, err)
+	assert.NotNil(t, q.PurgeTime)
+	assert.Empty(t, c.Server.Tasks(queuePath))
+
+	it := c.ListQueues(ctx, &taskspb.ListQueuesRequest{Parent: locationPath})
+	got, err := it.Next()
+	require.NoError(t, err)
+	assert.Equal(t, queuePath, got.Name)
+
+	require.NoError(t, c.DeleteQueue(ctx, &taskspb.DeleteQueueRequest{Name: queuePath}))
+	_, err = c.GetQueue(ctx, &taskspb.GetQueueRequest{Name: queuePath})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
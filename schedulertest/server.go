@@ -0,0 +1,415 @@
+// Package schedulertest provides an in-memory Cloud Tasks backend for tests of code built on scheduler.
+package schedulertest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultPageSize     = 1000
+	defaultTombstoneTTL = time.Hour
+)
+
+// FaultFunc is called before every RPC with its method name (e.g. "CreateTask").
+// A non-nil error is returned to the caller instead of handling the RPC.
+type FaultFunc func(ctx context.Context, method string, req proto.Message) error
+
+// FailMethod returns a FaultFunc which fails the first times calls of method with code.
+// A negative times fails every call.
+func FailMethod(method string, code codes.Code, times int) FaultFunc {
+	var mu sync.Mutex
+	return func(_ context.Context, m string, _ proto.Message) error {
+		if m != method {
+			return nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if times == 0 {
+			return nil
+		}
+		times--
+		return status.Errorf(code, "injected fault on %s", method)
+	}
+}
+
+type ServerOption func(*Server)
+
+// WithClock sets the clock used for creation times, default schedule times and tombstones.
+func WithClock(now func() time.Time) ServerOption {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+// WithTombstoneTTL sets how long names of deleted tasks can't be reused.
+// Cloud Tasks keeps them for about an hour; zero disables tombstoning.
+func WithTombstoneTTL(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.tombstoneTTL = d
+	}
+}
+
+// WithStrictQueues makes task RPCs fail with NotFound for queues which were not created,
+// instead of creating them implicitly.
+func WithStrictQueues() ServerOption {
+	return func(s *Server) {
+		s.strictQueues = true
+	}
+}
+
+// WithFault sets the fault injection hook.
+func WithFault(f FaultFunc) ServerOption {
+	return func(s *Server) {
+		s.fault = f
+	}
+}
+
+// Server is an in-memory implementation of the Cloud Tasks gRPC API.
+// Queues are created implicitly when tasks are added to them unless WithStrictQueues is given.
+type Server struct {
+	taskspb.UnimplementedCloudTasksServer
+
+	now          func() time.Time
+	tombstoneTTL time.Duration
+	strictQueues bool
+
+	mu         sync.Mutex
+	fault      FaultFunc
+	queues     map[string]*queue
+	dispatcher interface{ wake() }
+}
+
+type queue struct {
+	pb         *taskspb.Queue
+	tasks      map[string]*taskspb.Task
+	tombstones map[string]time.Time
+}
+
+var _ taskspb.CloudTasksServer = (*Server)(nil)
+
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		now:          time.Now,
+		tombstoneTTL: defaultTombstoneTTL,
+		queues:       make(map[string]*queue),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// SetFault replaces the fault injection hook. nil disables it.
+func (s *Server) SetFault(f FaultFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fault = f
+}
+
+// UnaryInterceptor applies the fault injection hook and should be installed on the grpc.Server serving s.
+func (s *Server) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	s.mu.Lock()
+	fault := s.fault
+	s.mu.Unlock()
+
+	if fault != nil {
+		msg, _ := req.(proto.Message)
+		if err := fault(ctx, path.Base(info.FullMethod), msg); err != nil {
+			return nil, err
+		}
+	}
+
+	return handler(ctx, req)
+}
+
+// Tasks returns a snapshot of the tasks in queuePath ordered by name.
+func (s *Server) Tasks(queuePath string) []*taskspb.Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.queues[queuePath]
+	if !ok {
+		return nil
+	}
+
+	return q.sortedTasks(taskspb.Task_FULL)
+}
+
+// AddTask stores task as is, bypassing validation and tombstones.
+func (s *Server) AddTask(task *taskspb.Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queue(queuePathOf(task.Name)).tasks[task.Name] = proto.Clone(task).(*taskspb.Task)
+}
+
+func (s *Server) ListTasks(_ context.Context, req *taskspb.ListTasksRequest) (*taskspb.ListTasksResponse, error) {
+	if err := validateQueuePath(req.Parent); err != nil {
+		return nil, err
+	}
+	if req.PageSize < 0 || req.PageSize > defaultPageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "page_size must be between 0 and %d", defaultPageSize)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.taskQueue(req.Parent)
+	if err != nil {
+		return nil, err
+	}
+	tasks := q.sortedTasks(req.ResponseView)
+
+	// page tokens are the name of the last task of the previous page, so pages stay consistent while tasks change.
+	start := 0
+	if req.PageToken != "" {
+		start = sort.Search(len(tasks), func(i int) bool { return tasks[i].Name > req.PageToken })
+	}
+	size := int(req.PageSize)
+	if size == 0 {
+		size = defaultPageSize
+	}
+	end := start + size
+	if end > len(tasks) {
+		end = len(tasks)
+	}
+
+	resp := &taskspb.ListTasksResponse{Tasks: tasks[start:end]}
+	if end < len(tasks) {
+		resp.NextPageToken = tasks[end-1].Name
+	}
+
+	return resp, nil
+}
+
+func (s *Server) GetTask(_ context.Context, req *taskspb.GetTaskRequest) (*taskspb.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.task(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return view(t, req.ResponseView), nil
+}
+
+func (s *Server) CreateTask(_ context.Context, req *taskspb.CreateTaskRequest) (*taskspb.Task, error) {
+	if err := validateQueuePath(req.Parent); err != nil {
+		return nil, err
+	}
+	if req.Task == nil {
+		return nil, status.Error(codes.InvalidArgument, "task is required")
+	}
+	if _, ok := req.Task.MessageType.(*taskspb.Task_HttpRequest); !ok {
+		return nil, status.Error(codes.InvalidArgument, "only http_request tasks are supported")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := proto.Clone(req.Task).(*taskspb.Task)
+	if t.Name == "" {
+		t.Name = req.Parent + "/tasks/" + randomID()
+	}
+	if queuePathOf(t.Name) != req.Parent {
+		return nil, status.Errorf(codes.InvalidArgument, "task name %s is not in queue %s", t.Name, req.Parent)
+	}
+
+	q, err := s.taskQueue(req.Parent)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := q.tasks[t.Name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "task %s already exists", t.Name)
+	}
+	if deletedAt, ok := q.tombstones[t.Name]; ok {
+		if s.now().Sub(deletedAt) < s.tombstoneTTL {
+			return nil, status.Errorf(codes.AlreadyExists, "task %s was deleted recently", t.Name)
+		}
+		delete(q.tombstones, t.Name)
+	}
+
+	now := timestamppb.New(s.now())
+	t.CreateTime = now
+	if t.ScheduleTime == nil {
+		t.ScheduleTime = now
+	}
+	q.tasks[t.Name] = t
+	if s.dispatcher != nil {
+		s.dispatcher.wake()
+	}
+
+	return view(t, req.ResponseView), nil
+}
+
+func (s *Server) DeleteTask(_ context.Context, req *taskspb.DeleteTaskRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.task(req.Name); err != nil {
+		return nil, err
+	}
+	s.removeTask(req.Name)
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) RunTask(_ context.Context, req *taskspb.RunTaskRequest) (*taskspb.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.task(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.dispatcher != nil {
+		t.ScheduleTime = timestamppb.New(s.now())
+		s.dispatcher.wake()
+		return view(t, req.ResponseView), nil
+	}
+
+	// without a dispatcher the task is considered to be executed successfully at once.
+	now := timestamppb.New(s.now())
+	t.DispatchCount++
+	t.ResponseCount++
+	t.FirstAttempt = firstAttempt(t.FirstAttempt, now)
+	t.LastAttempt = &taskspb.Attempt{ScheduleTime: t.ScheduleTime, DispatchTime: now, ResponseTime: now}
+	resp := view(t, req.ResponseView)
+	s.removeTask(req.Name)
+
+	return resp, nil
+}
+
+// queue returns the queue of queuePath, creating it if needed. s.mu must be held.
+func (s *Server) queue(queuePath string) *queue {
+	q, ok := s.queues[queuePath]
+	if !ok {
+		q = s.newQueue(queuePath)
+		s.queues[queuePath] = q
+	}
+
+	return q
+}
+
+// taskQueue returns the queue which task RPCs for queuePath operate on. s.mu must be held.
+func (s *Server) taskQueue(queuePath string) (*queue, error) {
+	if s.strictQueues {
+		return s.existingQueue(queuePath)
+	}
+
+	return s.queue(queuePath), nil
+}
+
+// task returns the stored task of name. s.mu must be held.
+func (s *Server) task(name string) (*taskspb.Task, error) {
+	if err := validateTaskName(name); err != nil {
+		return nil, err
+	}
+
+	q, ok := s.queues[queuePathOf(name)]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "task %s not found", name)
+	}
+	t, ok := q.tasks[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "task %s not found", name)
+	}
+
+	return t, nil
+}
+
+// removeTask deletes the task of name and tombstones its name. s.mu must be held.
+func (s *Server) removeTask(name string) {
+	q := s.queue(queuePathOf(name))
+	delete(q.tasks, name)
+	if s.tombstoneTTL > 0 {
+		q.tombstones[name] = s.now()
+	}
+}
+
+func (q *queue) sortedTasks(v taskspb.Task_View) []*taskspb.Task {
+	tasks := make([]*taskspb.Task, 0, len(q.tasks))
+	for _, t := range q.tasks {
+		tasks = append(tasks, view(t, v))
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Name < tasks[j].Name
+	})
+
+	return tasks
+}
+
+// view returns a copy of t as returned by Cloud Tasks for v. The BASIC view omits the request body.
+func view(t *taskspb.Task, v taskspb.Task_View) *taskspb.Task {
+	c := proto.Clone(t).(*taskspb.Task)
+	if v != taskspb.Task_FULL {
+		v = taskspb.Task_BASIC
+		if r, ok := c.MessageType.(*taskspb.Task_HttpRequest); ok {
+			r.HttpRequest.Body = nil
+		}
+	}
+	c.View = v
+
+	return c
+}
+
+func firstAttempt(a *taskspb.Attempt, dispatchTime *timestamppb.Timestamp) *taskspb.Attempt {
+	if a != nil {
+		return a
+	}
+
+	return &taskspb.Attempt{DispatchTime: dispatchTime}
+}
+
+func queuePathOf(taskName string) string {
+	if idx := strings.LastIndex(taskName, "/tasks/"); idx >= 0 {
+		return taskName[:idx]
+	}
+
+	return ""
+}
+
+func validateQueuePath(queuePath string) error {
+	parts := strings.Split(queuePath, "/")
+	if len(parts) != 6 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "queues" {
+		return status.Errorf(codes.InvalidArgument, "invalid queue name %q", queuePath)
+	}
+
+	return nil
+}
+
+func validateTaskName(name string) error {
+	if err := validateQueuePath(queuePathOf(name)); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid task name %q", name)
+	}
+
+	return nil
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
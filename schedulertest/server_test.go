@@ -0,0 +1,151 @@
+package schedulertest_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/iterator"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+const queuePath = "projects/tokyo-rain-123/locations/asia-northeast1/queues/scheduler"
+
+func newTask(id string) *taskspb.Task {
+	return &taskspb.Task{
+		Name:         queuePath + "/tasks/" + id,
+		ScheduleTime: timestamppb.New(time.Unix(10, 0)),
+		MessageType: &taskspb.Task_HttpRequest{
+			HttpRequest: &taskspb.HttpRequest{
+				Url:        "https://example.com/",
+				HttpMethod: taskspb.HttpMethod_POST,
+				Body:       []byte("body"),
+			},
+		},
+	}
+}
+
+func newFakeClient(t *testing.T, opts ...schedulertest.ServerOption) *schedulertest.FakeClient {
+	t.Helper()
+
+	c, err := schedulertest.NewFakeClient(context.Background(), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+func TestServer_ListTasks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := newFakeClient(t)
+	for i := 0; i < 5; i++ {
+		c.Server.AddTask(newTask(fmt.Sprintf("task%d", i)))
+	}
+	c.Server.AddTask(&taskspb.Task{Name: "projects/tokyo-rain-123/locations/asia-northeast1/queues/other/tasks/task"})
+
+	pager := iterator.NewPager(c.ListTasks(ctx, &taskspb.ListTasksRequest{Parent: queuePath}), 2, "")
+	var pages [][]string
+	for {
+		var page []*taskspb.Task
+		token, err := pager.NextPage(&page)
+		require.NoError(t, err)
+
+		var names []string
+		for _, task := range page {
+			names = append(names, task.Name[len(queuePath+"/tasks/"):])
+			assert.Nil(t, task.GetHttpRequest().Body, "basic view omits body")
+		}
+		pages = append(pages, names)
+		if token == "" {
+			break
+		}
+	}
+
+	assert.Equal(t, [][]string{{"task0", "task1"}, {"task2", "task3"}, {"task4"}}, pages)
+}
+
+func TestServer_CreateTask(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Unix(100, 0).UTC()
+	c := newFakeClient(t, schedulertest.WithClock(func() time.Time { return now }))
+
+	got, err := c.CreateTask(ctx, &taskspb.CreateTaskRequest{Parent: queuePath, Task: newTask("task"), ResponseView: taskspb.Task_FULL})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("body"), got.GetHttpRequest().Body)
+	assert.Equal(t, now, got.CreateTime.AsTime())
+
+	_, err = c.CreateTask(ctx, &taskspb.CreateTaskRequest{Parent: queuePath, Task: newTask("task")})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = c.CreateTask(ctx, &taskspb.CreateTaskRequest{Parent: "invalid", Task: newTask("task")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	got, err = c.CreateTask(ctx, &taskspb.CreateTaskRequest{Parent: queuePath, Task: &taskspb.Task{
+		MessageType: newTask("").MessageType,
+	}})
+	require.NoError(t, err)
+	assert.Regexp(t, "^"+queuePath+"/tasks/[0-9a-f]{32}$", got.Name)
+	assert.Equal(t, now, got.ScheduleTime.AsTime())
+}
+
+func TestServer_DeleteTask(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Unix(100, 0).UTC()
+	c := newFakeClient(t, schedulertest.WithClock(func() time.Time { return now }))
+	c.Server.AddTask(newTask("task"))
+
+	require.NoError(t, c.DeleteTask(ctx, &taskspb.DeleteTaskRequest{Name: queuePath + "/tasks/task"}))
+	assert.Empty(t, c.Server.Tasks(queuePath))
+
+	err := c.DeleteTask(ctx, &taskspb.DeleteTaskRequest{Name: queuePath + "/tasks/task"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// the name is tombstoned after deletion
+	_, err = c.CreateTask(ctx, &taskspb.CreateTaskRequest{Parent: queuePath, Task: newTask("task")})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	now = now.Add(time.Hour)
+	_, err = c.CreateTask(ctx, &taskspb.CreateTaskRequest{Parent: queuePath, Task: newTask("task")})
+	assert.NoError(t, err)
+}
+
+func TestServer_fault(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := newFakeClient(t, schedulertest.WithFault(schedulertest.FailMethod("CreateTask", codes.Internal, 1)))
+
+	_, err := c.CreateTask(ctx, &taskspb.CreateTaskRequest{Parent: queuePath, Task: newTask("task")})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	_, err = c.CreateTask(ctx, &taskspb.CreateTaskRequest{Parent: queuePath, Task: newTask("task")})
+	assert.NoError(t, err)
+
+	c.Server.SetFault(func(_ context.Context, method string, req proto.Message) error {
+		if r, ok := req.(*taskspb.GetTaskRequest); ok && r.Name == queuePath+"/tasks/task" {
+			return status.Error(codes.PermissionDenied, "denied")
+		}
+		return nil
+	})
+	_, err = c.GetTask(ctx, &taskspb.GetTaskRequest{Name: queuePath + "/tasks/task"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	c.Server.SetFault(nil)
+	_, err = c.GetTask(ctx, &taskspb.GetTaskRequest{Name: queuePath + "/tasks/task"})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,218 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"iter"
+
+	"github.com/googleapis/gax-go/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrUnsortedTasks  = errors.New("tasks are not sorted by name")
+	ErrDuplicateTasks = errors.New("duplicate tasks")
+)
+
+// syncEntry is a remote task in the index of SyncStream.
+// The task is kept without the request, which is compared by its digest.
+type syncEntry struct {
+	task   *Task
+	digest [sha256.Size]byte
+}
+
+// syncDigest returns the digest of the parts of t which Task.Compare compares besides the name.
+func syncDigest(t *Task) [sha256.Size]byte {
+	h := sha256.New()
+	if t.Request != nil {
+		fmt.Fprintf(h, "%s\n%s\n", t.Request.Method, removeTrailingSlash(t.Request.URL.String()))
+	}
+	switch a := t.Authorization.(type) {
+	case *OAuthToken:
+		fmt.Fprintf(h, "oauth\n%s\n%s\n", a.ServiceAccountEmail, a.Scope)
+	case *OIDCToken:
+		fmt.Fprintf(h, "oidc\n%s\n%s\n", a.ServiceAccountEmail, a.Audience)
+	}
+
+	var digest [sha256.Size]byte
+	h.Sum(digest[:0])
+	return digest
+}
+
+type streamSync struct {
+	s    *Scheduler
+	opts []gax.CallOption
+
+	index     map[string][]syncEntry
+	created   int
+	deleted   int
+	unchanged int
+}
+
+// SyncStream makes the remote tasks match desired as Sync does, but takes desired as an iterator
+// and applies the mutations as it goes, so that the desired tasks are never held at once.
+// The remote tasks are listed once into an index of their names and request digests without the requests,
+// so that SyncStream doesn't depend on the order of the listing, which Cloud Tasks doesn't guarantee.
+// desired must be sorted by task name with one task for an id and a scheduled time,
+// otherwise ErrUnsortedTasks or ErrDuplicateTasks is returned after the mutations made until then.
+// Unlike Sync, deletions and creations are interleaved.
+func (s *Scheduler) SyncStream(ctx context.Context, desired iter.Seq2[*Task, error], opts ...gax.CallOption) (err error) {
+	ctx, span := startSpan(ctx, s.tracer, "scheduler.SyncStream", s.attributes()...)
+	m := &streamSync{s: s, opts: opts, index: make(map[string][]syncEntry)}
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("scheduler.sync.create", m.created),
+			attribute.Int("scheduler.sync.delete", m.deleted),
+			attribute.Int("scheduler.sync.unchanged", m.unchanged),
+		)
+		s.addTasks(ctx, OutcomeUnchanged, m.unchanged)
+		outcome := OutcomeOK
+		if err != nil {
+			outcome = OutcomeError
+		}
+		endSpan(span, outcome, err)
+	}()
+
+	if err := m.load(ctx); err != nil {
+		return err
+	}
+
+	var lastName, lastKey string
+	for t, err := range desired {
+		if err != nil {
+			return fmt.Errorf("failed to iterate desired tasks: %w", err)
+		}
+		name, key := t.TaskID(), t.comparisonID()
+		if key == lastKey {
+			return fmt.Errorf("desired task %s: %w", name, ErrDuplicateTasks)
+		}
+		if name < lastName {
+			return fmt.Errorf("desired task %s: %w", name, ErrUnsortedTasks)
+		}
+		lastName, lastKey = name, key
+
+		if err := m.sync(ctx, t, key); err != nil {
+			return err
+		}
+	}
+
+	// the remote tasks left are not desired
+	for key, entries := range m.index {
+		for _, e := range entries {
+			if err := m.delete(ctx, e.task); err != nil {
+				return err
+			}
+			if s.registry != nil {
+				s.registry.Forget(e.task)
+			}
+		}
+		delete(m.index, key)
+	}
+
+	return nil
+}
+
+// load lists the remote tasks into the index, deleting the foreign tasks as they are found
+// so that at most a page of their names is kept.
+func (m *streamSync) load(ctx context.Context) error {
+	it := m.s.List(m.opts...)
+	defer it.Close()
+	var foreign []string
+	if m.s.cleanup {
+		it.WithMalformedTaskPolicy(ReportMalformedTask(func(name string, _ error) {
+			foreign = append(foreign, name)
+		}))
+	}
+
+	for {
+		t, err := it.Next(ctx)
+		if err != nil && !errors.Is(err, Done) {
+			return fmt.Errorf("failed to iterate remoteTasks: %w", err)
+		}
+
+		for ; len(foreign) > 0; foreign = foreign[1:] {
+			if err := m.s.Delete(ctx, foreign[0], m.opts...); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, Done) {
+			return nil
+		}
+
+		key := t.comparisonID()
+		m.index[key] = append(m.index[key], syncEntry{
+			task:   &Task{QueuePath: t.QueuePath, Prefix: t.Prefix, ID: t.ID, ScheduledAt: t.ScheduledAt, Version: t.Version},
+			digest: syncDigest(t),
+		})
+	}
+}
+
+// sync makes the remote tasks of key match t as Plan and Apply do.
+func (m *streamSync) sync(ctx context.Context, t *Task, key string) error {
+	entries := m.index[key]
+	delete(m.index, key)
+
+	// the first remote task equal to t is kept, and the others are deleted
+	digest := syncDigest(t)
+	kept := -1
+	for j, e := range entries {
+		if t.Version <= e.task.Version && e.digest == digest {
+			kept = j
+			break
+		}
+	}
+	if kept >= 0 {
+		m.unchanged++
+		if m.s.registry != nil {
+			m.s.registry.Record(entries[kept].task)
+		}
+	}
+
+	// the replaced versions are forgotten after the new one is recorded
+	var replaced []*Task
+	if m.s.registry != nil {
+		defer func() {
+			for _, r := range replaced {
+				m.s.registry.Forget(r)
+			}
+		}()
+	}
+	for j, e := range entries {
+		if j == kept {
+			continue
+		}
+		// delete remote task to update it
+		if kept < 0 && t.Version <= e.task.Version {
+			t.Version = e.task.Version + 1
+		}
+		if err := m.delete(ctx, e.task); err != nil {
+			return err
+		}
+		replaced = append(replaced, e.task)
+	}
+	if kept >= 0 {
+		return nil
+	}
+
+	if err := m.s.Create(ctx, t, m.opts...); err != nil {
+		return err
+	}
+	m.created++
+	if m.s.registry != nil {
+		m.s.registry.Record(t)
+	}
+
+	return nil
+}
+
+func (m *streamSync) delete(ctx context.Context, t *Task) error {
+	if err := m.s.Delete(ctx, t.TaskName(), m.opts...); err != nil {
+		return err
+	}
+	m.deleted++
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package scheduler_test
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/googleapis/gax-go/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/iterator"
+	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/go-oss/scheduler"
+	mock_scheduler "github.com/go-oss/scheduler/mock"
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+func sortedTasks(tasks []*scheduler.Task) iter.Seq2[*scheduler.Task, error] {
+	return func(yield func(*scheduler.Task, error) bool) {
+		for _, t := range tasks {
+			if !yield(t, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestScheduler_SyncStream(t *testing.T) {
+	t.Parallel()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	newTask := func(ctx context.Context, id, url string, at int64) *scheduler.Task {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		return &scheduler.Task{
+			QueuePath:   queuePath,
+			Prefix:      "test_",
+			ID:          id,
+			ScheduledAt: time.Unix(at, 0),
+			Request:     req,
+		}
+	}
+	remote := func(ctx context.Context) []*scheduler.Task {
+		return []*scheduler.Task{
+			newTask(ctx, "keep", "https://example.com/", 1),
+			newTask(ctx, "update", "https://example.com/", 1),
+			newTask(ctx, "delete", "https://example.com/", 1),
+			newTask(ctx, "keep", "https://example.com/", 16),
+		}
+	}
+	desired := func(ctx context.Context) []*scheduler.Task {
+		tasks := []*scheduler.Task{
+			newTask(ctx, "keep", "https://example.com/", 1),
+			newTask(ctx, "update", "https://example.com/new", 1),
+			newTask(ctx, "create", "https://example.com/", 1),
+			newTask(ctx, "keep", "https://example.com/", 16),
+			newTask(ctx, "zzz", "https://example.com/", 2),
+		}
+		slices.SortFunc(tasks, func(a, b *scheduler.Task) int { return strings.Compare(a.TaskID(), b.TaskID()) })
+		return tasks
+	}
+	run := func(t *testing.T, sync func(ctx context.Context, s *scheduler.Scheduler) error) []string {
+		ctx := context.Background()
+		cli, err := schedulertest.NewFakeClient(ctx)
+		require.NoError(t, err)
+		defer cli.Close()
+
+		s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_", scheduler.WithPrefetch(1))
+		require.NoError(t, s.Sync(ctx, remote(ctx)))
+		require.NoError(t, sync(ctx, s))
+		return taskNames(cli, queuePath)
+	}
+
+	want := run(t, func(ctx context.Context, s *scheduler.Scheduler) error {
+		return s.Sync(ctx, desired(ctx))
+	})
+	got := run(t, func(ctx context.Context, s *scheduler.Scheduler) error {
+		return s.SyncStream(ctx, sortedTasks(desired(ctx)))
+	})
+	assert.Equal(t, want, got, "same result as Sync")
+	assert.Contains(t, got, "test_update_3b9aca00v2")
+}
+
+func TestScheduler_SyncStream_order(t *testing.T) {
+	t.Parallel()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	newTask := func(ctx context.Context, id string, at int64) *scheduler.Task {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "https://example.com/", nil)
+		return &scheduler.Task{QueuePath: queuePath, Prefix: "test_", ID: id, ScheduledAt: time.Unix(at, 0), Request: req}
+	}
+	newPbTask := func(name string, at *timestamppb.Timestamp) *taskspb.Task {
+		return &taskspb.Task{
+			Name:         queuePath + "/tasks/" + name,
+			ScheduleTime: at,
+			MessageType:  &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: "https://example.com/", HttpMethod: taskspb.HttpMethod_POST}},
+		}
+	}
+
+	t.Run("unsorted desired", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		cli, err := schedulertest.NewFakeClient(ctx)
+		require.NoError(t, err)
+		defer cli.Close()
+
+		s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+		err = s.SyncStream(ctx, sortedTasks([]*scheduler.Task{newTask(ctx, "b", 1), newTask(ctx, "a", 1)}))
+		assert.ErrorIs(t, err, scheduler.ErrUnsortedTasks)
+		assert.Equal(t, []string{"test_b_3b9aca00v1"}, taskNames(cli, queuePath), "mutations before the error are applied")
+	})
+
+	t.Run("duplicate desired", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		cli, err := schedulertest.NewFakeClient(ctx)
+		require.NoError(t, err)
+		defer cli.Close()
+
+		s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+		err = s.SyncStream(ctx, sortedTasks([]*scheduler.Task{newTask(ctx, "a", 1), newTask(ctx, "a", 1)}))
+		assert.ErrorIs(t, err, scheduler.ErrDuplicateTasks)
+	})
+
+	t.Run("names sorted apart from the versions", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		cli, err := schedulertest.NewFakeClient(ctx)
+		require.NoError(t, err)
+		defer cli.Close()
+		cli.Server.AddTask(newPbTask("test_a_1v0_5v1", &timestamppb.Timestamp{Nanos: 5}))
+		cli.Server.AddTask(newPbTask("test_a_1v3", &timestamppb.Timestamp{Nanos: 1}))
+
+		s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+		desired := []*scheduler.Task{newTask(ctx, "a_1v0", 0), newTask(ctx, "a", 0)}
+		desired[0].ScheduledAt, desired[0].Version = time.Unix(0, 5), 1
+		desired[1].ScheduledAt, desired[1].Version = time.Unix(0, 1), 3
+		slices.SortFunc(desired, func(a, b *scheduler.Task) int { return strings.Compare(a.TaskID(), b.TaskID()) })
+		require.NoError(t, s.SyncStream(ctx, sortedTasks(desired)))
+		assert.Equal(t, []string{"test_a_1v0_5v1", "test_a_1v3"}, taskNames(cli, queuePath), "unchanged")
+	})
+
+	t.Run("unsorted remote", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		ctrl := gomock.NewController(t)
+		m := mock_scheduler.NewMockCloudTasksClient(ctrl)
+		l := mock_scheduler.NewMockTaskLister(ctrl)
+		i := mock_scheduler.NewMockTaskIterator(ctrl)
+		l.EXPECT().ListTasks(gomock.Any(), gomock.Any()).Return(i)
+		i.EXPECT().PageInfo().Return(&iterator.PageInfo{})
+		for _, name := range []string{"test_c_3b9aca00v1", "test_b_3b9aca00v1", "test_a_3b9aca00v1"} {
+			i.EXPECT().Next().Return(newPbTask(name, &timestamppb.Timestamp{Seconds: 1}), nil)
+		}
+		i.EXPECT().Next().Return(nil, iterator.Done)
+		m.EXPECT().DeleteTask(gomock.Any(), &taskspb.DeleteTaskRequest{Name: queuePath + "/tasks/test_c_3b9aca00v1"}).Return(nil)
+
+		s := scheduler.New(m, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+		s.SetIterator(func(opts ...gax.CallOption) *scheduler.Iterator {
+			return scheduler.NewIterator(l, queuePath, "test_", opts...)
+		})
+		require.NoError(t, s.SyncStream(ctx, sortedTasks([]*scheduler.Task{newTask(ctx, "a", 1), newTask(ctx, "b", 1)})))
+	})
+}
+
+func TestScheduler_SyncStream_foreignTaskCleanup(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	cli, err := schedulertest.NewFakeClient(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	for _, name := range []string{"test_a_3b9aca00v1", "test_broken", "test_c_3b9aca00v1", "test_zzz"} {
+		cli.Server.AddTask(&taskspb.Task{
+			Name:         queuePath + "/tasks/" + name,
+			ScheduleTime: &timestamppb.Timestamp{Seconds: 1},
+			MessageType:  &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{Url: "https://example.com/", HttpMethod: taskspb.HttpMethod_POST}},
+		})
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "https://example.com/", nil)
+	desired := &scheduler.Task{QueuePath: queuePath, Prefix: "test_", ID: "a", ScheduledAt: time.Unix(1, 0), Request: req}
+
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_", scheduler.WithForeignTaskCleanup())
+	require.NoError(t, s.SyncStream(ctx, sortedTasks([]*scheduler.Task{desired})))
+	assert.Equal(t, []string{"test_a_3b9aca00v1"}, taskNames(cli, queuePath))
+}
@@ -124,30 +124,51 @@ func removeTrailingSlash(u string) string {
 }
 
 func ParseTaskName(prefix, taskName string) (string, int, error) {
+	id, _, version, err := splitTaskName(prefix, taskName)
+	return id, version, err
+}
+
+// splitTaskName splits taskName into the id, the encoded scheduled time and the version.
+func splitTaskName(prefix, taskName string) (string, string, int, error) {
 	v := path.Base(taskName)
 
 	if !strings.HasPrefix(v, prefix) {
-		return "", 0, fmt.Errorf("task name has no valid prefix: %w", ErrInvalidTaskName)
+		return "", "", 0, fmt.Errorf("task name has no valid prefix: %w", ErrInvalidTaskName)
 	}
 
 	v = strings.TrimPrefix(v, prefix)
 	tsIdx := strings.LastIndex(v, taskTimestampSeparator)
 	if tsIdx < 0 {
-		return "", 0, fmt.Errorf("invalid task name format: %w", ErrInvalidTaskName)
+		return "", "", 0, fmt.Errorf("invalid task name format: %w", ErrInvalidTaskName)
 	}
 
 	id := v[:tsIdx]
 	v = v[tsIdx+1:]
 	vIdx := strings.LastIndex(v, taskVersionSeparator)
 	if vIdx < 0 {
-		return "", 0, fmt.Errorf("task name has no valid version: %w", ErrInvalidTaskName)
+		return "", "", 0, fmt.Errorf("task name has no valid version: %w", ErrInvalidTaskName)
 	}
 
 	vs := v[vIdx+1:]
 	version, err := strconv.Atoi(vs)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to parse version (%s): %w", vs, ErrInvalidTaskName)
+		return "", "", 0, fmt.Errorf("failed to parse version (%s): %w", vs, ErrInvalidTaskName)
+	}
+
+	return id, v[:vIdx], version, nil
+}
+
+// parseTaskName is ParseTaskName which also decodes the scheduled time encoded in the task name.
+func parseTaskName(prefix, taskName string) (string, time.Time, int, error) {
+	id, ts, version, err := splitTaskName(prefix, taskName)
+	if err != nil {
+		return "", time.Time{}, 0, err
+	}
+
+	nsec, err := strconv.ParseInt(ts, 16, 64)
+	if err != nil {
+		return "", time.Time{}, 0, fmt.Errorf("failed to parse scheduled time (%s): %w", ts, ErrInvalidTaskName)
 	}
 
-	return id, version, nil
+	return id, time.Unix(0, nsec), version, nil
 }
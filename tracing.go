@@ -0,0 +1,65 @@
+package scheduler
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/go-oss/scheduler"
+
+// Attribute keys of spans.
+const (
+	AttributeQueue       = attribute.Key("scheduler.queue")
+	AttributePrefix      = attribute.Key("scheduler.prefix")
+	AttributeTaskID      = attribute.Key("scheduler.task.id")
+	AttributeTaskVersion = attribute.Key("scheduler.task.version")
+	AttributeOutcome     = attribute.Key("scheduler.outcome")
+)
+
+// Outcomes of operations.
+const (
+	OutcomeCreated       = "created"
+	OutcomeDeleted       = "deleted"
+	OutcomeAlreadyExists = "already_exists"
+	OutcomeError         = "error"
+	OutcomeOK            = "ok"
+)
+
+// WithTracerProvider enables spans around Sync, Create, Delete and pages listed by Iterator.
+// The trace context of Create is injected into the headers of the task request,
+// so the delivery can be linked to the span which scheduled it.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *Scheduler) {
+		s.tracer = tp.Tracer(instrumentationName)
+	}
+}
+
+// WithPropagator sets the propagator which injects the trace context into task requests.
+// The default is W3C Trace Context.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(s *Scheduler) {
+		s.propagator = p
+	}
+}
+
+// startSpan starts a span if tracing is enabled, otherwise returns a span which records nothing.
+func startSpan(ctx context.Context, tracer trace.Tracer, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+func endSpan(span trace.Span, outcome string, err error) {
+	span.SetAttributes(AttributeOutcome.String(outcome))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
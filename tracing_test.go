@@ -0,0 +1,84 @@
+package scheduler_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/go-oss/scheduler"
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+func TestScheduler_tracing(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_", scheduler.WithTracerProvider(tp))
+
+	newTask := func(url string) *scheduler.Task {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		return &scheduler.Task{
+			QueuePath:   queuePath,
+			Prefix:      "test_",
+			ID:          "id",
+			ScheduledAt: time.Unix(1, 0),
+			Request:     req,
+		}
+	}
+	require.NoError(t, s.Sync(ctx, []*scheduler.Task{newTask("https://example.com/")}))
+	require.NoError(t, s.Sync(ctx, []*scheduler.Task{newTask("https://example.com/v2")}))
+
+	type span struct {
+		name    string
+		outcome string
+		version int64
+	}
+	var got []span
+	var syncSpans []sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		attrs := attribute.NewSet(s.Attributes()...)
+		outcome, _ := attrs.Value(scheduler.AttributeOutcome)
+		version, _ := attrs.Value(scheduler.AttributeTaskVersion)
+		got = append(got, span{name: s.Name(), outcome: outcome.AsString(), version: version.AsInt64()})
+		if s.Name() == "scheduler.Sync" {
+			syncSpans = append(syncSpans, s)
+		}
+	}
+	assert.Equal(t, []span{
+		{name: "scheduler.ListTasks", outcome: "ok"},
+		{name: "scheduler.Create", outcome: "created", version: 1},
+		{name: "scheduler.Sync", outcome: "ok"},
+		{name: "scheduler.ListTasks", outcome: "ok"},
+		{name: "scheduler.Delete", outcome: "deleted", version: 1},
+		{name: "scheduler.Create", outcome: "created", version: 2},
+		{name: "scheduler.Sync", outcome: "ok"},
+	}, got)
+
+	var createSpan sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == "scheduler.Create" {
+			createSpan = s
+		}
+	}
+	require.Len(t, syncSpans, 2)
+	assert.Equal(t, syncSpans[1].SpanContext().SpanID(), createSpan.Parent().SpanID())
+
+	tasks := cli.Server.Tasks(queuePath)
+	require.Len(t, tasks, 1)
+	traceparent := tasks[0].GetHttpRequest().Headers["traceparent"]
+	assert.Equal(t, "00-"+createSpan.SpanContext().TraceID().String()+"-"+createSpan.SpanContext().SpanID().String()+"-01", traceparent)
+}
@@ -0,0 +1,169 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// VersionSource provides the current version of the task identified by id and scheduledAt.
+// ok is false if the task is unknown.
+type VersionSource interface {
+	CurrentVersion(ctx context.Context, id string, scheduledAt time.Time) (version int, ok bool, err error)
+}
+
+func versionKey(id string, scheduledAt time.Time) string {
+	return id + taskTimestampSeparator + strconv.FormatInt(scheduledAt.UnixNano(), 16)
+}
+
+// VersionRegistry is a VersionSource kept in memory.
+// It is fed by Scheduler.Apply with WithVersionRegistry.
+type VersionRegistry struct {
+	mu       sync.RWMutex
+	versions map[string]int
+}
+
+func NewVersionRegistry() *VersionRegistry {
+	return &VersionRegistry{
+		versions: make(map[string]int),
+	}
+}
+
+// Record records the version of t unless a newer version is recorded.
+func (r *VersionRegistry) Record(t *Task) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := versionKey(t.ID, t.ScheduledAt)
+	if v, ok := r.versions[key]; !ok || v < t.Version {
+		r.versions[key] = t.Version
+	}
+}
+
+// Forget removes the version of t unless a newer version is recorded.
+func (r *VersionRegistry) Forget(t *Task) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := versionKey(t.ID, t.ScheduledAt)
+	if v, ok := r.versions[key]; ok && v <= t.Version {
+		delete(r.versions, key)
+	}
+}
+
+func (r *VersionRegistry) CurrentVersion(_ context.Context, id string, scheduledAt time.Time) (int, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	v, ok := r.versions[versionKey(id, scheduledAt)]
+	return v, ok, nil
+}
+
+// QueueVersionSource is a VersionSource which looks up the tasks in the queue.
+// The listed versions are cached for ttl, so versions created within ttl may not be seen.
+// An expired cache is refreshed by one caller at a time, and the others are answered from it meanwhile.
+type QueueVersionSource struct {
+	scheduler *Scheduler
+	ttl       time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	versions  map[string]int
+	expiresAt time.Time
+	// refreshing is closed when the refresh in progress ends
+	refreshing chan struct{}
+}
+
+// NewQueueVersionSource returns a QueueVersionSource of the tasks of s. It panics if ttl is not positive.
+func NewQueueVersionSource(s *Scheduler, ttl time.Duration) *QueueVersionSource {
+	if ttl <= 0 {
+		panic("scheduler: non-positive ttl")
+	}
+
+	return &QueueVersionSource{
+		scheduler: s,
+		ttl:       ttl,
+		now:       time.Now,
+	}
+}
+
+func (q *QueueVersionSource) CurrentVersion(ctx context.Context, id string, scheduledAt time.Time) (int, bool, error) {
+	key := versionKey(id, scheduledAt)
+
+	q.mu.Lock()
+	for q.versions == nil || !q.now().Before(q.expiresAt) {
+		if q.refreshing == nil {
+			q.refreshing = make(chan struct{})
+			q.mu.Unlock()
+			return q.refresh(ctx, key)
+		}
+		if q.versions != nil {
+			// the stale versions are kept until the refresh ends
+			break
+		}
+
+		refreshing := q.refreshing
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return 0, false, ctx.Err()
+		case <-refreshing:
+		}
+		q.mu.Lock()
+	}
+	defer q.mu.Unlock()
+
+	v, ok := q.versions[key]
+	return v, ok, nil
+}
+
+// refresh lists the versions in the queue without the lock and returns the version of key.
+func (q *QueueVersionSource) refresh(ctx context.Context, key string) (int, bool, error) {
+	versions, err := q.list(ctx)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	close(q.refreshing)
+	q.refreshing = nil
+	if err != nil {
+		return 0, false, err
+	}
+	q.versions = versions
+	q.expiresAt = q.now().Add(q.ttl)
+
+	v, ok := versions[key]
+	return v, ok, nil
+}
+
+func (q *QueueVersionSource) list(ctx context.Context) (map[string]int, error) {
+	versions := make(map[string]int)
+	iter := q.scheduler.List()
+	defer iter.Close()
+	for {
+		t, err := iter.Next(ctx)
+		if err != nil {
+			if errors.Is(err, Done) {
+				return versions, nil
+			}
+			return nil, fmt.Errorf("failed to list tasks: %w", err)
+		}
+		key := versionKey(t.ID, t.ScheduledAt)
+		if v, ok := versions[key]; !ok || v < t.Version {
+			versions[key] = t.Version
+		}
+	}
+}
+
+// IsSuperseded reports whether a newer version than info exists in src.
+// Unknown tasks are not regarded as superseded.
+func IsSuperseded(ctx context.Context, src VersionSource, info *TaskInfo) (bool, error) {
+	v, ok, err := src.CurrentVersion(ctx, info.ID, info.ScheduledAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to get current version: %w", err)
+	}
+
+	return ok && info.Version < v, nil
+}
@@ -0,0 +1,227 @@
+package scheduler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/go-oss/scheduler"
+	"github.com/go-oss/scheduler/schedulertest"
+)
+
+func TestReceiver_versionSource(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { cli.Close() })
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	newTasks := func(url string) []*scheduler.Task {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		return []*scheduler.Task{
+			{
+				QueuePath:   queuePath,
+				Prefix:      "test_",
+				ID:          "id",
+				ScheduledAt: time.Unix(1, 0),
+				Request:     req,
+			},
+		}
+	}
+
+	registry := scheduler.NewVersionRegistry()
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_", scheduler.WithVersionRegistry(registry))
+	require.NoError(t, s.Sync(ctx, newTasks("https://example.com/")))
+	require.NoError(t, s.Sync(ctx, newTasks("https://example.com/v2")))
+
+	tests := []struct {
+		name       string
+		src        scheduler.VersionSource
+		taskName   string
+		wantCalled bool
+	}{
+		{
+			name:       "registry: current version",
+			src:        registry,
+			taskName:   "test_id_3b9aca00v2",
+			wantCalled: true,
+		},
+		{
+			name:     "registry: superseded version",
+			src:      registry,
+			taskName: "test_id_3b9aca00v1",
+		},
+		{
+			name:       "registry: unknown task",
+			src:        registry,
+			taskName:   "test_other_3b9aca00v1",
+			wantCalled: true,
+		},
+		{
+			name:       "queue: current version",
+			src:        scheduler.NewQueueVersionSource(s, time.Minute),
+			taskName:   "test_id_3b9aca00v2",
+			wantCalled: true,
+		},
+		{
+			name:     "queue: superseded version",
+			src:      scheduler.NewQueueVersionSource(s, time.Minute),
+			taskName: "test_id_3b9aca00v1",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var called bool
+			h := scheduler.NewReceiver("test_", scheduler.WithVersionSource(tt.src)).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusAccepted)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header.Set("X-CloudTasks-TaskName", tt.taskName)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantCalled, called)
+			if !tt.wantCalled {
+				assert.Equal(t, http.StatusOK, rec.Code, "superseded deliveries are acknowledged")
+			}
+		})
+	}
+}
+
+func TestScheduler_Apply_registry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	newTasks := func(url string) []*scheduler.Task {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		return []*scheduler.Task{{QueuePath: queuePath, Prefix: "test_", ID: "id", ScheduledAt: time.Unix(1, 0), Request: req}}
+	}
+
+	registry := scheduler.NewVersionRegistry()
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_", scheduler.WithVersionRegistry(registry))
+	require.NoError(t, s.Sync(ctx, newTasks("https://example.com/")))
+
+	var known []bool
+	cli.Server.SetFault(func(ctx context.Context, method string, _ proto.Message) error {
+		if method == "CreateTask" {
+			_, ok, _ := registry.CurrentVersion(ctx, "id", time.Unix(1, 0))
+			known = append(known, ok)
+		}
+		return nil
+	})
+	require.NoError(t, s.Sync(ctx, newTasks("https://example.com/v2")))
+	assert.Equal(t, []bool{true}, known, "the deleted version is kept while the new one is created")
+
+	v, ok, err := registry.CurrentVersion(ctx, "id", time.Unix(1, 0))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+}
+
+func TestVersionRegistry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	r := scheduler.NewVersionRegistry()
+	at := time.Unix(1, 0)
+
+	r.Record(&scheduler.Task{ID: "id", ScheduledAt: at, Version: 2})
+	r.Record(&scheduler.Task{ID: "id", ScheduledAt: at, Version: 1})
+	v, ok, err := r.CurrentVersion(ctx, "id", at)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2, v, "older versions don't overwrite")
+
+	r.Forget(&scheduler.Task{ID: "id", ScheduledAt: at, Version: 1})
+	_, ok, _ = r.CurrentVersion(ctx, "id", at)
+	assert.True(t, ok, "forgetting older versions keeps the newer one")
+
+	r.Forget(&scheduler.Task{ID: "id", ScheduledAt: at, Version: 2})
+	_, ok, _ = r.CurrentVersion(ctx, "id", at)
+	assert.False(t, ok)
+}
+
+func TestQueueVersionSource_refresh(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cli, err := schedulertest.NewFakeClient(ctx)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	queuePath := scheduler.QueuePath("tokyo-rain-123", "asia-northeast1", "scheduler")
+	s := scheduler.New(cli, "tokyo-rain-123", "asia-northeast1", "scheduler", "test_")
+	newTask := func(version int) *scheduler.Task {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "https://example.com/", nil)
+		return &scheduler.Task{QueuePath: queuePath, Prefix: "test_", ID: "id", ScheduledAt: time.Unix(1, 0), Request: req, Version: version}
+	}
+	require.NoError(t, s.Create(ctx, newTask(1)))
+
+	now := time.Unix(100, 0)
+	src := scheduler.NewQueueVersionSource(s, time.Minute)
+	src.SetNow(func() time.Time { return now })
+	v, ok, err := src.CurrentVersion(ctx, "id", time.Unix(1, 0))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	require.NoError(t, s.Create(ctx, newTask(2)))
+	now = now.Add(time.Minute)
+	var (
+		listed  atomic.Int32
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	cli.Server.SetFault(func(ctx context.Context, method string, _ proto.Message) error {
+		if method == "ListTasks" && listed.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		return nil
+	})
+
+	refreshed := make(chan int)
+	go func() {
+		v, _, err := src.CurrentVersion(ctx, "id", time.Unix(1, 0))
+		assert.NoError(t, err)
+		refreshed <- v
+	}()
+	<-started
+
+	v, ok, err = src.CurrentVersion(ctx, "id", time.Unix(1, 0))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, v, "the stale version is returned while refreshing")
+	assert.Equal(t, int32(1), listed.Load(), "refreshes are not duplicated")
+
+	close(release)
+	assert.Equal(t, 2, <-refreshed)
+	v, _, err = src.CurrentVersion(ctx, "id", time.Unix(1, 0))
+	require.NoError(t, err)
+	assert.Equal(t, 2, v)
+	assert.Equal(t, int32(1), listed.Load())
+}
+
+func TestNewQueueVersionSource_ttl(t *testing.T) {
+	t.Parallel()
+
+	assert.Panics(t, func() { scheduler.NewQueueVersionSource(nil, 0) })
+}